@@ -1,47 +1,49 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/30x/enrober/pkg/config"
 	"github.com/30x/enrober/pkg/server"
-
-	"k8s.io/kubernetes/pkg/client/restclient"
 )
 
 func main() {
 
-	//Default to local client
-	clientConfig := restclient.Config{
-		Host: "127.0.0.1:8080",
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+	for _, warning := range cfg.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
 	}
 
-	envState := os.Getenv("DEPLOY_STATE")
-
-	switch envState {
-	case "PROD":
-		fmt.Printf("DEPLOY_STATE set to PROD\n")
-		clientConfig.Host = ""
-	case "DEV_CONTAINER":
-		fmt.Printf("DEPLOY_STATE set to DEV_CONTAINER\n")
-		clientConfig.Host = ""
-	case "DEV":
-		fmt.Printf("DEPLOY_STATE set to DEV\n")
-		clientConfig.Host = "127.0.0.1:8080"
+	switch cfg.DeployState {
+	case config.DeployStateProd, config.DeployStateDevContainer, config.DeployStateDev:
+		fmt.Printf("DEPLOY_STATE set to %s\n", cfg.DeployState)
 	default:
 		fmt.Printf("Defaulting to Local Dev Setup\n")
 	}
 
-	err := server.Init(clientConfig)
+	err = server.Init(cfg)
 	if err != nil {
 		fmt.Printf("Error initializing server: %v\n", err)
-		return
+		os.Exit(1)
 	}
 
-	server := server.NewServer()
-	err = server.Start()
+	//Finish in flight requests before exiting when the pod is terminated
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	server := server.NewServer(cfg)
+	err = server.Start(ctx)
 	if err != nil {
-		fmt.Printf("Error starting server\n")
+		fmt.Printf("Error starting server: %v\n", err)
+		os.Exit(1)
 	}
 
 	return
@@ -0,0 +1,309 @@
+package apigee
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/30x/enrober/pkg/helper"
+	"github.com/30x/enrober/pkg/metrics"
+)
+
+//DefaultHost is the Apigee management API used when AUTH_API_HOST isn't set
+const DefaultHost = "api.enterprise.apigee.com"
+
+//cpsProperty is the organization property that says whether an org has Core Persistence Services enabled
+const cpsProperty = "features.isCpsEnabled"
+
+//Client talks to the Apigee management API.
+//Every call passes through the Authorization header of the request that caused it.
+type Client struct {
+	//BaseURL is the scheme and host of the management API, for example https://api.enterprise.apigee.com
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+//NewClient creates a Client for the management API at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{},
+	}
+}
+
+//KVMEntry is a single key of a key value map
+type KVMEntry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+//KVM is an environment scoped key value map
+type KVM struct {
+	Name  string     `json:"name"`
+	Entry []KVMEntry `json:"entry"`
+}
+
+//Error is a non-success response from the management API
+type Error struct {
+	StatusCode int      `json:"-"`
+	Code       string   `json:"code"`
+	Message    string   `json:"message"`
+	Contexts   []string `json:"contexts"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Apigee returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Apigee returned %d: %s", e.StatusCode, e.Message)
+}
+
+//IsNotFound is true when err is a 404 from the management API
+func IsNotFound(err error) bool {
+	apigeeErr, ok := err.(*Error)
+	return ok && apigeeErr.StatusCode == http.StatusNotFound
+}
+
+//IsConflict is true when err is a 409 from the management API
+func IsConflict(err error) bool {
+	apigeeErr, ok := err.(*Error)
+	return ok && apigeeErr.StatusCode == http.StatusConflict
+}
+
+//IsServerError is true when err is a 5xx from the management API
+func IsServerError(err error) bool {
+	apigeeErr, ok := err.(*Error)
+	return ok && apigeeErr.StatusCode >= 500
+}
+
+//CreateKVM creates a key value map in an environment
+func (c *Client) CreateKVM(authz, org, env string, kvm KVM) error {
+	return c.do("POST", c.kvmURL(org, env), authz, kvm, http.StatusCreated, nil)
+}
+
+//GetKVM gets a key value map from an environment
+func (c *Client) GetKVM(authz, org, env, name string) (*KVM, error) {
+	kvm := &KVM{}
+	err := c.do("GET", c.kvmURL(org, env, name), authz, nil, http.StatusOK, kvm)
+	if err != nil {
+		return nil, err
+	}
+	return kvm, nil
+}
+
+//UpdateKVM replaces every entry of a key value map. Orgs with CPS enabled don't support this, see UpdateKVMEntries.
+func (c *Client) UpdateKVM(authz, org, env string, kvm KVM) error {
+	return c.do("POST", c.kvmURL(org, env, kvm.Name), authz, kvm, http.StatusOK, nil)
+}
+
+//UpdateKVMEntry replaces a single entry of a key value map
+func (c *Client) UpdateKVMEntry(authz, org, env, kvmName string, entry KVMEntry) error {
+	return c.do("POST", c.kvmURL(org, env, kvmName, "entries", entry.Name), authz, entry, http.StatusOK, nil)
+}
+
+//DeleteKVM deletes a key value map from an environment
+func (c *Client) DeleteKVM(authz, org, env, name string) error {
+	return c.do("DELETE", c.kvmURL(org, env, name), authz, nil, http.StatusOK, nil)
+}
+
+//DeleteKVMEntry deletes a single entry of a key value map
+func (c *Client) DeleteKVMEntry(authz, org, env, kvmName, entryName string) error {
+	return c.do("DELETE", c.kvmURL(org, env, kvmName, "entries", entryName), authz, nil, http.StatusOK, nil)
+}
+
+//RemoveKVM deletes a key value map using whichever endpoints the org supports.
+//With CPS the entries are deleted one at a time before the map itself so no key outlives a failed delete.
+//A map that is already gone isn't an error.
+func (c *Client) RemoveKVM(authz, org, env, name string) error {
+	cpsEnabled, err := c.IsCPSEnabled(authz, org)
+	if err != nil {
+		return err
+	}
+
+	if cpsEnabled {
+		kvm, err := c.GetKVM(authz, org, env, name)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range kvm.Entry {
+			err = c.DeleteKVMEntry(authz, org, env, name, entry.Name)
+			if err != nil && !IsNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	err = c.DeleteKVM(authz, org, env, name)
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+//UpdateKVMEntries replaces the entries of an existing key value map using whichever endpoint the org supports.
+//With CPS each entry has to be updated on its own, without it the whole map is sent at once.
+func (c *Client) UpdateKVMEntries(authz, org, env string, kvm KVM) error {
+	cpsEnabled, err := c.IsCPSEnabled(authz, org)
+	if err != nil {
+		return err
+	}
+
+	if !cpsEnabled {
+		return c.UpdateKVM(authz, org, env, kvm)
+	}
+
+	for _, entry := range kvm.Entry {
+		err = c.UpdateKVMEntry(authz, org, env, kvm.Name, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Ping checks that the management API can be reached and isn't failing.
+//Client errors count as up, there are no credentials to send outside of a request.
+func (c *Client) Ping(timeout time.Duration) error {
+	pingClient := *c.HTTPClient
+	pingClient.Timeout = timeout
+
+	resp, err := pingClient.Get(c.BaseURL + "/v1")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+	return nil
+}
+
+//organization is the part of the organization resource we read
+type organization struct {
+	Properties *struct {
+		Property []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"property"`
+	} `json:"properties"`
+}
+
+//IsCPSEnabled looks up whether an org has Core Persistence Services enabled.
+//An org without the property, or without any properties, doesn't.
+func (c *Client) IsCPSEnabled(authz, org string) (bool, error) {
+	var orgBody organization
+	err := c.do("GET", fmt.Sprintf("%s/v1/organizations/%s", c.BaseURL, url.PathEscape(org)), authz, nil, http.StatusOK, &orgBody)
+	if err != nil {
+		return false, err
+	}
+
+	if orgBody.Properties == nil {
+		return false, nil
+	}
+
+	for _, prop := range orgBody.Properties.Property {
+		if prop.Name == cpsProperty {
+			return prop.Value == "true", nil
+		}
+	}
+	return false, nil
+}
+
+//kvmURL builds the URL of the key value maps of an environment, extra path segments are appended as is
+func (c *Client) kvmURL(org, env string, segments ...string) string {
+	kvmURL := fmt.Sprintf("%s/v1/organizations/%s/environments/%s/keyvaluemaps", c.BaseURL, url.PathEscape(org), url.PathEscape(env))
+	for _, segment := range segments {
+		kvmURL += "/" + url.PathEscape(segment)
+	}
+	return kvmURL
+}
+
+//describeURL names the kind of resource a management API URL is for, to label metrics
+func describeURL(u *url.URL) string {
+	switch {
+	case strings.Contains(u.Path, "/entries/"):
+		return "kvm_entry"
+	case strings.Contains(u.Path, "/keyvaluemaps"):
+		return "kvm"
+	case strings.Contains(u.Path, "/organizations/"):
+		return "organization"
+	}
+	return "unknown"
+}
+
+//do sends a JSON request and decodes the response into out when the status is expectedStatus.
+//Any other status is returned as an *Error.
+func (c *Client) do(method, requestURL, authz string, body interface{}, expectedStatus int, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b := new(bytes.Buffer)
+		err := json.NewEncoder(b).Encode(body)
+		if err != nil {
+			return fmt.Errorf("Error encoding Apigee request: %v", err)
+		}
+		reqBody = b
+	}
+
+	req, err := http.NewRequest(method, requestURL, reqBody)
+	if err != nil {
+		return fmt.Errorf("Unable to create Apigee request: %v", err)
+	}
+
+	//Must pass through the authz header
+	req.Header.Add("Authorization", authz)
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	helper.LogInfo.Printf("Apigee request: %s %s\n", method, req.URL.String())
+
+	start := time.Now()
+	resource := describeURL(req.URL)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		metrics.ObserveApigee(method, resource, "error", time.Since(start))
+		return fmt.Errorf("Error calling Apigee: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == expectedStatus:
+		metrics.ObserveApigee(method, resource, "success", time.Since(start))
+	case resp.StatusCode < 500:
+		metrics.ObserveApigee(method, resource, "client_error", time.Since(start))
+	default:
+		metrics.ObserveApigee(method, resource, "server_error", time.Since(start))
+	}
+
+	if resp.StatusCode != expectedStatus {
+		apigeeErr := &Error{StatusCode: resp.StatusCode}
+
+		//Error bodies are usually {code, message, contexts} but don't rely on it
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(respBody, apigeeErr) != nil {
+			apigeeErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return apigeeErr
+	}
+
+	if out != nil {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			return fmt.Errorf("Error decoding Apigee response: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package apigee
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testResponse struct {
+	status int
+	body   string
+}
+
+//testServer answers each "METHOD path" with a fixed status and body and records what was called
+func testServer(responses map[string]testResponse) (*httptest.Server, *[]string) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := r.Method + " " + r.URL.Path
+		calls = append(calls, call)
+		resp, ok := responses[call]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("no such resource"))
+			return
+		}
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	return server, &calls
+}
+
+func TestIsCPSEnabled(t *testing.T) {
+	server, _ := testServer(map[string]testResponse{
+		"GET /v1/organizations/cps":     {200, `{"properties": {"property": [{"name": "features.isCpsEnabled", "value": "true"}]}}`},
+		"GET /v1/organizations/noprops": {200, `{"name": "noprops"}`},
+		"GET /v1/organizations/broken":  {502, `Bad Gateway`},
+	})
+	defer server.Close()
+
+	c := NewClient(server.URL)
+
+	enabled, err := c.IsCPSEnabled("", "cps")
+	if err != nil || !enabled {
+		t.Errorf("Expected CPS to be enabled, got %v, %v\n", enabled, err)
+	}
+
+	//Used to panic on orgs without properties
+	enabled, err = c.IsCPSEnabled("", "noprops")
+	if err != nil || enabled {
+		t.Errorf("Expected CPS to be disabled, got %v, %v\n", enabled, err)
+	}
+
+	_, err = c.IsCPSEnabled("", "broken")
+	if !IsServerError(err) {
+		t.Errorf("Expected a server error, got %v\n", err)
+	}
+}
+
+func TestCreateKVMConflict(t *testing.T) {
+	server, _ := testServer(map[string]testResponse{
+		"POST /v1/organizations/org/environments/env/keyvaluemaps": {409, `{"code": "keymanagement.service.kmsAlreadyExists", "message": "already exists", "contexts": []}`},
+	})
+	defer server.Close()
+
+	err := NewClient(server.URL).CreateKVM("", "org", "env", KVM{Name: "routing"})
+	if !IsConflict(err) {
+		t.Fatalf("Expected a conflict, got %v\n", err)
+	}
+	if err.(*Error).Code != "keymanagement.service.kmsAlreadyExists" {
+		t.Errorf("Expected the error code to be decoded, got %v\n", err.(*Error).Code)
+	}
+}
+
+func TestGetKVMNotFound(t *testing.T) {
+	server, _ := testServer(nil)
+	defer server.Close()
+
+	_, err := NewClient(server.URL).GetKVM("", "org", "env", "routing")
+	if !IsNotFound(err) {
+		t.Errorf("Expected not found, got %v\n", err)
+	}
+}
+
+func TestUpdateKVMEntriesWithCPS(t *testing.T) {
+	server, calls := testServer(map[string]testResponse{
+		"GET /v1/organizations/org": {200, `{"properties": {"property": [{"name": "features.isCpsEnabled", "value": "true"}]}}`},
+		"POST /v1/organizations/org/environments/env/keyvaluemaps/routing/entries/public-key": {200, `{}`},
+	})
+	defer server.Close()
+
+	err := NewClient(server.URL).UpdateKVMEntries("", "org", "env", KVM{
+		Name:  "routing",
+		Entry: []KVMEntry{{Name: "public-key", Value: "abc"}},
+	})
+	if err != nil {
+		t.Fatalf("Error from UpdateKVMEntries: %v\n", err)
+	}
+	if len(*calls) != 2 {
+		t.Errorf("Expected the CPS lookup and one entry update, got %v\n", *calls)
+	}
+}
+
+func TestUpdateKVMEntriesWithoutCPS(t *testing.T) {
+	server, calls := testServer(map[string]testResponse{
+		"GET /v1/organizations/org":                                        {200, `{"properties": {"property": []}}`},
+		"POST /v1/organizations/org/environments/env/keyvaluemaps/routing": {200, `{}`},
+	})
+	defer server.Close()
+
+	err := NewClient(server.URL).UpdateKVMEntries("", "org", "env", KVM{
+		Name:  "routing",
+		Entry: []KVMEntry{{Name: "public-key", Value: "abc"}},
+	})
+	if err != nil {
+		t.Fatalf("Error from UpdateKVMEntries: %v\n", err)
+	}
+	if (*calls)[1] != "POST /v1/organizations/org/environments/env/keyvaluemaps/routing" {
+		t.Errorf("Expected the whole KVM to be updated, got %v\n", *calls)
+	}
+}
+
+func TestRemoveKVMWithCPS(t *testing.T) {
+	server, calls := testServer(map[string]testResponse{
+		"GET /v1/organizations/org":                                                             {200, `{"properties": {"property": [{"name": "features.isCpsEnabled", "value": "true"}]}}`},
+		"GET /v1/organizations/org/environments/env/keyvaluemaps/routing":                       {200, `{"name": "routing", "entry": [{"name": "public-key", "value": "abc"}]}`},
+		"DELETE /v1/organizations/org/environments/env/keyvaluemaps/routing/entries/public-key": {200, `{}`},
+		"DELETE /v1/organizations/org/environments/env/keyvaluemaps/routing":                    {200, `{}`},
+	})
+	defer server.Close()
+
+	err := NewClient(server.URL).RemoveKVM("", "org", "env", "routing")
+	if err != nil {
+		t.Fatalf("Error from RemoveKVM: %v\n", err)
+	}
+	if len(*calls) != 4 {
+		t.Errorf("Expected the entry to be deleted before the KVM, got %v\n", *calls)
+	}
+}
+
+func TestRemoveKVMAlreadyGone(t *testing.T) {
+	server, _ := testServer(map[string]testResponse{
+		"GET /v1/organizations/org": {200, `{}`},
+	})
+	defer server.Close()
+
+	err := NewClient(server.URL).RemoveKVM("", "org", "env", "routing")
+	if err != nil {
+		t.Errorf("Expected a missing KVM to be ignored, got %v\n", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	server, _ := testServer(map[string]testResponse{
+		"GET /v1": {401, `Unauthorized`},
+	})
+
+	c := NewClient(server.URL)
+
+	//Being refused without credentials still means the API is up
+	err := c.Ping(time.Second)
+	if err != nil {
+		t.Errorf("Expected a reachable API, got %v\n", err)
+	}
+
+	server.Close()
+	err = c.Ping(time.Second)
+	if err == nil {
+		t.Errorf("Expected an error once the API is gone\n")
+	}
+}
+
+func TestPingServerError(t *testing.T) {
+	server, _ := testServer(map[string]testResponse{
+		"GET /v1": {503, `Service Unavailable`},
+	})
+	defer server.Close()
+
+	err := NewClient(server.URL).Ping(time.Second)
+	if !IsServerError(err) {
+		t.Errorf("Expected a server error, got %v\n", err)
+	}
+}
+
+func TestKVMURLEscapesPathSegments(t *testing.T) {
+	c := NewClient("https://apigee.example.com")
+
+	expected := "https://apigee.example.com/v1/organizations/my%20org/environments/test%2Fenv/keyvaluemaps/a+b"
+	got := c.kvmURL("my org", "test/env", "a+b")
+	if got != expected {
+		t.Errorf("Expected %s, got %s\n", expected, got)
+	}
+}
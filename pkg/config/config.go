@@ -0,0 +1,378 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/30x/enrober/pkg/apigee"
+)
+
+//Deploy states, anything but PROD disables the checks that need a real Apigee and shipyard
+const (
+	DeployStateLocal        = ""
+	DeployStateDev          = "DEV"
+	DeployStateDevContainer = "DEV_CONTAINER"
+	DeployStateProd         = "PROD"
+)
+
+//Config is everything enrober can be configured with.
+//Settings come from the defaults, then a YAML file, then environment variables, then command line flags, later ones winning.
+type Config struct {
+	//DeployState is PROD, DEV_CONTAINER, DEV or empty for local development
+	DeployState string `json:"deployState"`
+	//Port is the port the API listens on
+	Port int `json:"port"`
+	//KubeHost is the kubernetes API used outside of a cluster, in PROD and DEV_CONTAINER the in cluster config is used
+	KubeHost string `json:"kubeHost"`
+
+	//AdminPort serves health checks apart from the API, 0 turns it off.
+	//When it isn't set and the API already uses it, health checks stay with the API.
+	AdminPort int `json:"adminPort"`
+	//The API is served over TLS when both are set, the files are reloaded when they change
+	TLSCertFile string `json:"tlsCertFile"`
+	TLSKeyFile  string `json:"tlsKeyFile"`
+	//ReadTimeout and WriteTimeout bound a single request, 0 is no limit. A write timeout also ends followed logs.
+	ReadTimeout  Duration `json:"readTimeout"`
+	WriteTimeout Duration `json:"writeTimeout"`
+	//ShutdownTimeout is how long in flight requests get to finish after SIGTERM
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+
+	//The following are only honored in PROD
+	IsolateNamespace          bool `json:"isolateNamespace"`
+	AllowPrivilegedContainers bool `json:"allowPrivilegedContainers"`
+	ApigeeKVM                 bool `json:"apigeeKVM"`
+
+	//ApigeeHost is the Apigee management API host
+	ApigeeHost string `json:"apigeeHost"`
+	//ImagePullSecret is the secret each environment pulls images with, the secrets API won't touch it
+	ImagePullSecret string `json:"imagePullSecret"`
+
+	//KVMReconcileInterval turns on the background KVM reconciler when set
+	KVMReconcileInterval      Duration `json:"kvmReconcileInterval"`
+	KVMReconcileFix           bool     `json:"kvmReconcileFix"`
+	KVMReconcileAuthorization string   `json:"kvmReconcileAuthorization"`
+
+	//KeyExpiryInterval is how often routing secrets are swept for previous keys past their grace period, 0 turns it off
+	KeyExpiryInterval Duration `json:"keyExpiryInterval"`
+
+	//ReadinessCache is how long readiness probes reuse passing access and Apigee checks, 0 checks on every probe
+	ReadinessCache Duration `json:"readinessCache"`
+
+	//Used to fetch pod template specs from shipyard through the internal router
+	ShipyardHost          string `json:"shipyardHost"`
+	InternalRouterHost    string `json:"internalRouterHost"`
+	ShipyardPrivateSecret string `json:"shipyardPrivateSecret"`
+	APIRoutingKeyHeader   string `json:"apiRoutingKeyHeader"`
+
+	//Warnings are what Load found suspicious but not wrong enough to refuse, for the caller to log
+	Warnings []string `json:"-"`
+}
+
+//Duration is a time.Duration written as a string like "10m"
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+//MarshalJSON writes a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+//Default is the configuration used for anything that isn't set
+func Default() *Config {
+	return &Config{
+		DeployState:         DeployStateLocal,
+		Port:                9000,
+		KubeHost:            "127.0.0.1:8080",
+		AdminPort:           9001,
+		ReadTimeout:         Duration{time.Minute},
+		ShutdownTimeout:     Duration{30 * time.Second},
+		ApigeeHost:          apigee.DefaultHost,
+		KeyExpiryInterval:   Duration{time.Minute},
+		ReadinessCache:      Duration{time.Minute},
+		APIRoutingKeyHeader: "X-ROUTING-API-KEY",
+	}
+}
+
+//Prod is true when running in production, where callers must be org admins
+func (c *Config) Prod() bool {
+	return c.DeployState == DeployStateProd
+}
+
+//InCluster is true when the kubernetes client should use the in cluster config rather than KubeHost
+func (c *Config) InCluster() bool {
+	return c.DeployState == DeployStateProd || c.DeployState == DeployStateDevContainer
+}
+
+//Validate checks the configuration is usable
+func (c *Config) Validate() error {
+	switch c.DeployState {
+	case DeployStateLocal, DeployStateDev, DeployStateDevContainer, DeployStateProd:
+	default:
+		return fmt.Errorf("Invalid deployState %q, must be PROD, DEV_CONTAINER, DEV or empty", c.DeployState)
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("Invalid port %d", c.Port)
+	}
+
+	if c.AdminPort < 0 || c.AdminPort > 65535 || c.AdminPort == c.Port {
+		return fmt.Errorf("Invalid adminPort %d", c.AdminPort)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tlsCertFile and tlsKeyFile must be set together")
+	}
+
+	if c.ReadTimeout.Duration < 0 || c.WriteTimeout.Duration < 0 || c.ShutdownTimeout.Duration < 0 {
+		return fmt.Errorf("Timeouts can't be negative")
+	}
+
+	if !c.InCluster() && c.KubeHost == "" {
+		return fmt.Errorf("kubeHost is required unless deployState is PROD or DEV_CONTAINER")
+	}
+
+	if c.ApigeeHost == "" {
+		return fmt.Errorf("apigeeHost can't be empty")
+	}
+
+	if c.KVMReconcileInterval.Duration < 0 {
+		return fmt.Errorf("Invalid kvmReconcileInterval %v", c.KVMReconcileInterval)
+	}
+	if c.KVMReconcileFix && c.KVMReconcileInterval.Duration == 0 {
+		return fmt.Errorf("kvmReconcileFix needs kvmReconcileInterval")
+	}
+
+	if c.KeyExpiryInterval.Duration < 0 {
+		return fmt.Errorf("Invalid keyExpiryInterval %v", c.KeyExpiryInterval)
+	}
+
+	if c.ReadinessCache.Duration < 0 {
+		return fmt.Errorf("Invalid readinessCache %v", c.ReadinessCache)
+	}
+
+	if (c.ShipyardHost == "") != (c.InternalRouterHost == "") {
+		return fmt.Errorf("shipyardHost and internalRouterHost must be set together")
+	}
+
+	return nil
+}
+
+//setting is a field of Config that can be set from an environment variable and, unless flag is empty, a command line flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	field func(c *Config) interface{}
+}
+
+//settings lists every environment variable and flag. Secrets have no flag so they don't show up in ps.
+var settings = []setting{
+	{"DEPLOY_STATE", "deploy-state", "PROD, DEV_CONTAINER, DEV or empty for local", func(c *Config) interface{} { return &c.DeployState }},
+	{"PORT", "port", "port to listen on", func(c *Config) interface{} { return &c.Port }},
+	{"KUBE_HOST", "kube-host", "kubernetes API host outside of a cluster", func(c *Config) interface{} { return &c.KubeHost }},
+	{"ADMIN_PORT", "admin-port", "port for health checks, 0 to serve them with the API", func(c *Config) interface{} { return &c.AdminPort }},
+	{"TLS_CERT_FILE", "tls-cert-file", "certificate to serve the API over TLS", func(c *Config) interface{} { return &c.TLSCertFile }},
+	{"TLS_KEY_FILE", "tls-key-file", "private key of the TLS certificate", func(c *Config) interface{} { return &c.TLSKeyFile }},
+	{"READ_TIMEOUT", "read-timeout", "longest time to read a request, 0 for no limit", func(c *Config) interface{} { return &c.ReadTimeout }},
+	{"WRITE_TIMEOUT", "write-timeout", "longest time to write a response, 0 for no limit", func(c *Config) interface{} { return &c.WriteTimeout }},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long in flight requests get to finish on shutdown", func(c *Config) interface{} { return &c.ShutdownTimeout }},
+	{"ISOLATE_NAMESPACE", "isolate-namespace", "deny ingress between namespaces (PROD only)", func(c *Config) interface{} { return &c.IsolateNamespace }},
+	{"ALLOW_PRIV_CONTAINERS", "allow-privileged-containers", "allow privileged containers (PROD only)", func(c *Config) interface{} { return &c.AllowPrivilegedContainers }},
+	{"APIGEE_KVM", "apigee-kvm", "keep the routing public key in an Apigee KVM (PROD only)", func(c *Config) interface{} { return &c.ApigeeKVM }},
+	{"AUTH_API_HOST", "apigee-host", "Apigee management API host", func(c *Config) interface{} { return &c.ApigeeHost }},
+	{"IMAGE_PULL_SECRET", "image-pull-secret", "name of the image pull secret of each environment", func(c *Config) interface{} { return &c.ImagePullSecret }},
+	{"KVM_RECONCILE_INTERVAL", "kvm-reconcile-interval", "how often to compare KVMs with routing secrets, 0 to never", func(c *Config) interface{} { return &c.KVMReconcileInterval }},
+	{"KVM_RECONCILE_FIX", "kvm-reconcile-fix", "push routing secrets to KVMs that don't match", func(c *Config) interface{} { return &c.KVMReconcileFix }},
+	{"KVM_RECONCILE_AUTHORIZATION", "", "", func(c *Config) interface{} { return &c.KVMReconcileAuthorization }},
+	{"KEY_EXPIRY_INTERVAL", "key-expiry-interval", "how often to drop previous routing keys past their grace period, 0 to never", func(c *Config) interface{} { return &c.KeyExpiryInterval }},
+	{"READINESS_CACHE", "readiness-cache", "how long readiness probes reuse passing access and Apigee checks, 0 to check every time", func(c *Config) interface{} { return &c.ReadinessCache }},
+	{"SHIPYARD_HOST", "shipyard-host", "host of PTS URLs fetched through the internal router", func(c *Config) interface{} { return &c.ShipyardHost }},
+	{"INTERNAL_ROUTER_HOST", "internal-router-host", "internal router used to reach shipyard", func(c *Config) interface{} { return &c.InternalRouterHost }},
+	{"SHIPYARD_PRIVATE_SECRET", "", "", func(c *Config) interface{} { return &c.ShipyardPrivateSecret }},
+	{"API_ROUTING_KEY_HEADER", "api-routing-key-header", "header carrying the routing key to the internal router", func(c *Config) interface{} { return &c.APIRoutingKeyHeader }},
+}
+
+//set parses value into the field of a setting
+func (s setting) set(c *Config, value string) error {
+	var err error
+	switch field := s.field(c).(type) {
+	case *string:
+		*field = value
+	case *bool:
+		*field, err = strconv.ParseBool(value)
+	case *int:
+		*field, err = strconv.Atoi(value)
+	case *Duration:
+		field.Duration, err = time.ParseDuration(value)
+	}
+	return err
+}
+
+func (s setting) isBool() bool {
+	_, ok := s.field(Default()).(*bool)
+	return ok
+}
+
+//flagValue records a flag's raw value so flags can be applied after the file and environment
+type flagValue struct {
+	raw    map[string]string
+	name   string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.raw[f.name] }
+func (f *flagValue) Set(v string) error { f.raw[f.name] = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+//Load builds the configuration from the defaults, the YAML file given by -config or ENROBER_CONFIG,
+//the environment and the command line arguments, then validates it
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("enrober", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("ENROBER_CONFIG"), "YAML config file")
+
+	rawFlags := map[string]string{}
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.Var(&flagValue{raw: rawFlags, name: s.flag, isBool: s.isBool()}, s.flag, s.usage+", or $"+s.env)
+		}
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("Unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	c := Default()
+
+	var fileKeys map[string]json.RawMessage
+	if *configFile != "" {
+		fileKeys, err = c.loadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range unknownEnv(os.Environ()) {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("Ignoring unknown environment variable %s", name))
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			err = s.set(c, value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s %q: %v", s.env, value, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := rawFlags[s.flag]; ok && s.flag != "" {
+			err = s.set(c, value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid -%s %q: %v", s.flag, value, err)
+			}
+		}
+	}
+
+	//Health checks were served with the API before there was an admin port, so a deploy already on
+	//the default admin port keeps them there rather than failing to start
+	_, adminPortEnv := os.LookupEnv("ADMIN_PORT")
+	_, adminPortFlag := rawFlags["admin-port"]
+	_, adminPortFile := fileKeys["adminPort"]
+	if c.AdminPort == c.Port && !adminPortEnv && !adminPortFlag && !adminPortFile {
+		c.AdminPort = 0
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//loadFile applies a YAML config file, unknown keys are an error so typos don't go unnoticed
+//It returns the keys the file sets.
+func (c *Config) loadFile(path string) (map[string]json.RawMessage, error) {
+	y, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config file: %v", err)
+	}
+
+	js, err := yaml.YAMLToJSON(y)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing config file %s: %v", path, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(c)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing config file %s: %v", path, err)
+	}
+
+	keys := map[string]json.RawMessage{}
+	err = json.Unmarshal(js, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing config file %s: %v", path, err)
+	}
+	return keys, nil
+}
+
+//serviceEnvRegex matches the variables kubernetes sets for services, like ENROBER_SERVICE_HOST and ENROBER_PORT_9000_TCP
+var serviceEnvRegex = regexp.MustCompile(`_(SERVICE_HOST|SERVICE_PORT(_.+)?|PORT(_[0-9]+_(TCP|UDP|SCTP)(_.+)?)?)$`)
+
+//unknownEnv lists the environment variables that look like settings but aren't one, so typos don't go unnoticed.
+//Variables look like settings when they start with ENROBER_ or with all but the last word of a setting's variable,
+//like KVM_RECONCILE_. Single words like API_ are left alone, other programs share them.
+func unknownEnv(environ []string) []string {
+	known := map[string]bool{"ENROBER_CONFIG": true}
+	prefixes := []string{"ENROBER_"}
+	for _, s := range settings {
+		known[s.env] = true
+		if i := strings.LastIndex(s.env, "_"); strings.Count(s.env[:i+1], "_") > 1 {
+			prefixes = append(prefixes, s.env[:i+1])
+		}
+	}
+
+	var unknown []string
+	for _, variable := range environ {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if known[name] || serviceEnvRegex.MatchString(name) {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				unknown = append(unknown, name)
+				break
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//writeConfig writes a YAML config file and returns its path
+func writeConfig(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "enrober-config")
+	if err != nil {
+		t.Fatalf("Error creating config file: %v\n", err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(contents)
+	if err != nil {
+		t.Fatalf("Error writing config file: %v\n", err)
+	}
+	return f.Name()
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Error loading defaults: %v\n", err)
+	}
+	if c.Port != 9000 || c.InCluster() || c.Prod() {
+		t.Errorf("Unexpected defaults: %+v\n", c)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+port: 9100
+apigeeHost: file.example.com
+kubeHost: file:8080
+kvmReconcileInterval: 10m
+`)
+	defer os.Remove(path)
+
+	os.Setenv("AUTH_API_HOST", "env.example.com")
+	os.Setenv("KUBE_HOST", "env:8080")
+	defer os.Unsetenv("AUTH_API_HOST")
+	defer os.Unsetenv("KUBE_HOST")
+
+	c, err := Load([]string{"-config", path, "-kube-host", "flag:8080"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v\n", err)
+	}
+
+	if c.Port != 9100 {
+		t.Errorf("Expected the port from the file, got %d\n", c.Port)
+	}
+	if c.ApigeeHost != "env.example.com" {
+		t.Errorf("Expected the environment to override the file, got %s\n", c.ApigeeHost)
+	}
+	if c.KubeHost != "flag:8080" {
+		t.Errorf("Expected the flag to override the environment, got %s\n", c.KubeHost)
+	}
+	if c.KVMReconcileInterval.Duration != 10*time.Minute {
+		t.Errorf("Expected a 10m interval, got %v\n", c.KVMReconcileInterval)
+	}
+}
+
+func TestLoadBoolFlag(t *testing.T) {
+	c, err := Load([]string{"-deploy-state", "PROD", "-apigee-kvm"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v\n", err)
+	}
+	if !c.ApigeeKVM || !c.InCluster() {
+		t.Errorf("Expected a PROD config with the KVM on, got %+v\n", c)
+	}
+}
+
+func TestLoadRejectsTypos(t *testing.T) {
+	path := writeConfig(t, "isolateNamespaces: true\n")
+	defer os.Remove(path)
+
+	_, err := Load([]string{"-config", path})
+	if err == nil {
+		t.Errorf("Expected an unknown key in the file to fail\n")
+	}
+
+	os.Setenv("APIGEE_KVM", "ture")
+	_, err = Load(nil)
+	os.Unsetenv("APIGEE_KVM")
+	if err == nil {
+		t.Errorf("Expected an invalid boolean to fail\n")
+	}
+
+	os.Setenv("DEPLOY_STATE", "PRODUCTION")
+	_, err = Load(nil)
+	os.Unsetenv("DEPLOY_STATE")
+	if err == nil {
+		t.Errorf("Expected an unknown deploy state to fail\n")
+	}
+}
+
+func TestLoadDefaultAdminPortMakesWay(t *testing.T) {
+	os.Setenv("PORT", "9001")
+	defer os.Unsetenv("PORT")
+
+	//Deploys from before the admin port keep their health checks on the API port
+	c, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v\n", err)
+	}
+	if c.AdminPort != 0 {
+		t.Errorf("Expected the admin listener to be off, got port %d\n", c.AdminPort)
+	}
+
+	//Asking for the same port twice is still a mistake
+	os.Setenv("ADMIN_PORT", "9001")
+	_, err = Load(nil)
+	os.Unsetenv("ADMIN_PORT")
+	if err == nil {
+		t.Errorf("Expected an admin port on the API port to fail\n")
+	}
+}
+
+func TestLoadWarnsOfUnknownEnv(t *testing.T) {
+	os.Setenv("KVM_RECONCILE_INTERVALL", "10m")
+	os.Setenv("ENROBER_PORT_9000_TCP", "tcp://10.0.0.1:9000")
+	os.Setenv("API_TIMEOUT", "10s")
+	defer os.Unsetenv("KVM_RECONCILE_INTERVALL")
+	defer os.Unsetenv("ENROBER_PORT_9000_TCP")
+	defer os.Unsetenv("API_TIMEOUT")
+
+	c, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v\n", err)
+	}
+	if len(c.Warnings) != 1 || !strings.Contains(c.Warnings[0], "KVM_RECONCILE_INTERVALL") {
+		t.Errorf("Expected a warning about KVM_RECONCILE_INTERVALL only, got %v\n", c.Warnings)
+	}
+}
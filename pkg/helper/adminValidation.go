@@ -12,20 +12,44 @@ func ValidAdmin(organization string, w http.ResponseWriter, r *http.Request) boo
 	token, err := authsdk.NewJWTTokenFromRequest(r)
 	if err != nil {
 		fmt.Printf("Error getting JWT Token: %v\n", err)
-		http.Error(w, "Invalid Token", http.StatusUnauthorized) //401
+		WriteError(w, "Invalid Token", http.StatusUnauthorized) //401
 		return false
 	}
 	isAdmin, err := token.IsOrgAdmin(organization)
 	if err != nil {
 		fmt.Printf("Error checking caller is an Org Admin: %v\n", err) //401
-		http.Error(w, "", http.StatusUnauthorized)
+		WriteError(w, "Unable to check Org Admin", http.StatusUnauthorized)
 		return false
 	}
 	if !isAdmin {
 		//Throwing a 403
 		fmt.Printf("Caller isn't an Org Admin\n")
-		http.Error(w, "You aren't an Org Admin", http.StatusForbidden) //403
+		WriteError(w, "You aren't an Org Admin", http.StatusForbidden) //403
 		return false
 	}
 	return true
 }
+
+//AdminOrganizations filters organizations down to the ones the caller is an org admin of.
+//Orgs that can't be checked are left out. Without a valid token it writes a 401 and returns false.
+func AdminOrganizations(organizations []string, w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	token, err := authsdk.NewJWTTokenFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error getting JWT Token: %v\n", err)
+		WriteError(w, "Invalid Token", http.StatusUnauthorized) //401
+		return nil, false
+	}
+
+	var adminOrgs []string
+	for _, organization := range organizations {
+		isAdmin, err := token.IsOrgAdmin(organization)
+		if err != nil {
+			fmt.Printf("Error checking caller is an Org Admin of %s: %v\n", organization, err)
+			continue
+		}
+		if isAdmin {
+			adminOrgs = append(adminOrgs, organization)
+		}
+	}
+	return adminOrgs, true
+}
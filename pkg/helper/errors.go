@@ -0,0 +1,58 @@
+package helper
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+//RequestIDHeader carries the id of a request, it's echoed on the response and returned in error bodies
+const RequestIDHeader = "X-Request-Id"
+
+//ErrorResponse is the body of every error the API returns, the same shape as the errors Apigee returns
+type ErrorResponse struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Details   []string `json:"details,omitempty"`
+	RequestID string   `json:"requestId"`
+}
+
+//errorCodes are the machine readable codes for the statuses we return
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "BadRequest",
+	http.StatusUnauthorized:        "Unauthorized",
+	http.StatusForbidden:           "Forbidden",
+	http.StatusNotFound:            "NotFound",
+	http.StatusConflict:            "Conflict",
+	http.StatusInternalServerError: "InternalError",
+	http.StatusBadGateway:          "UpstreamError",
+	http.StatusServiceUnavailable:  "Unavailable",
+	http.StatusGatewayTimeout:      "Timeout",
+}
+
+//NewErrorResponse builds the error body for a status, picking up the request id from the response headers
+func NewErrorResponse(w http.ResponseWriter, message string, status int, details ...string) ErrorResponse {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = strings.Replace(http.StatusText(status), " ", "", -1)
+	}
+	return ErrorResponse{
+		Code:      code,
+		Message:   strings.TrimSpace(message),
+		Details:   details,
+		RequestID: w.Header().Get(RequestIDHeader),
+	}
+}
+
+//WriteError replies to the request with an ErrorResponse, it takes the place of http.Error
+func WriteError(w http.ResponseWriter, message string, status int, details ...string) {
+	js, err := json.Marshal(NewErrorResponse(w, message, status, details...))
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -4,11 +4,12 @@ import (
 	"strings"
 
 	"k8s.io/kubernetes/pkg/api"
-	k8sClient "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/30x/enrober/pkg/kubeclient"
 )
 
 //UniqueHostNames checks if the desired hostNames are unique among existing namespaces
-func UniqueHostNames(hostNames []string, client k8sClient.Client) (bool, error) {
+func UniqueHostNames(hostNames []string, client kubeclient.Interface) (bool, error) {
 	for _, value := range hostNames {
 		//Get list of all namespace and loop through each of their "validHosts" annotation looking for strings matching our value
 		nsList, err := client.Namespaces().List(api.ListOptions{})
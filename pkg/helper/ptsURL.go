@@ -7,55 +7,77 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"os"
+	"time"
 
 	"k8s.io/kubernetes/pkg/api"
-)
 
-var (
-	shipyardHost          string
-	internalRouterHost    string
-	shipyardPrivateSecret string
-	apiRoutingKeyHeader   string
+	"github.com/30x/enrober/pkg/metrics"
 )
 
+//PTSConfig is how GetPTSFromURL reaches shipyard
+type PTSConfig struct {
+	//RestrictHost only allows PTS URLs on the host the request was made to
+	RestrictHost bool
+	//PTS URLs on ShipyardHost are fetched through InternalRouterHost with ShipyardPrivateSecret as the routing key
+	ShipyardHost          string
+	InternalRouterHost    string
+	ShipyardPrivateSecret string
+	APIRoutingKeyHeader   string
+}
+
+var ptsConfig = PTSConfig{
+	APIRoutingKeyHeader: "X-ROUTING-API-KEY",
+}
+
+//SetPTSConfig sets how GetPTSFromURL reaches shipyard, it's called once at startup
+func SetPTSConfig(c PTSConfig) {
+	ptsConfig = c
+}
+
+//InvalidPTSURLError is returned by GetPTSFromURL when the URL itself is unusable, as opposed to fetching it failing
+type InvalidPTSURLError struct {
+	message string
+}
+
+func (e *InvalidPTSURLError) Error() string {
+	return e.message
+}
+
 //GetPTSFromURL gets a pod template spec from a given URL
 func GetPTSFromURL(ptsURLString string, request *http.Request) (api.PodTemplateSpec, error) {
+	start := time.Now()
+	pts, err := getPTSFromURL(ptsURLString, request)
+	metrics.ObservePTSFetch(time.Since(start), err)
+	return pts, err
+}
+
+func getPTSFromURL(ptsURLString string, request *http.Request) (api.PodTemplateSpec, error) {
 
 	httpClient := &http.Client{}
 
 	ptsURL, err := url.Parse(ptsURLString)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error parsing ptsURL\n")
-		return api.PodTemplateSpec{}, errors.New(errorMessage)
+		return api.PodTemplateSpec{}, &InvalidPTSURLError{errorMessage}
 	}
 
 	//This could be moved up
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if ptsConfig.RestrictHost {
 		u, err := url.Parse(ptsURLString)
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error parsing ptsURL: %s\n", err)
-			return api.PodTemplateSpec{}, errors.New(errorMessage)
+			return api.PodTemplateSpec{}, &InvalidPTSURLError{errorMessage}
 		}
 		if u.Host != request.Host {
 			errorMessage := fmt.Sprintf("Attempting to use PTS from unauthorized host: %v, expected: %v\n", u.Host, request.Host)
-			return api.PodTemplateSpec{}, errors.New(errorMessage)
+			return api.PodTemplateSpec{}, &InvalidPTSURLError{errorMessage}
 		}
 	}
 
-	//Get the necesarry environment variables
-	shipyardHost = os.Getenv("SHIPYARD_HOST")
-	internalRouterHost = os.Getenv("INTERNAL_ROUTER_HOST")
-	shipyardPrivateSecret = os.Getenv("SHIPYARD_PRIVATE_SECRET")
-	apiRoutingKeyHeader = os.Getenv("API_ROUTING_KEY_HEADER")
-	if apiRoutingKeyHeader == "" {
-		apiRoutingKeyHeader = "X-ROUTING-API-KEY"
-	}
-
 	internalRouterFlag := false
 
-	if ptsURL.Host == shipyardHost {
-		ptsURL.Host = internalRouterHost
+	if ptsConfig.ShipyardHost != "" && ptsURL.Host == ptsConfig.ShipyardHost {
+		ptsURL.Host = ptsConfig.InternalRouterHost
 		ptsURL.Scheme = "http"
 		internalRouterFlag = true
 	}
@@ -63,9 +85,9 @@ func GetPTSFromURL(ptsURLString string, request *http.Request) (api.PodTemplateS
 	req, err := http.NewRequest("GET", ptsURL.String(), nil)
 
 	if internalRouterFlag {
-		req.Host = shipyardHost
-		req.Header.Add("Host", shipyardHost)
-		req.Header.Add(apiRoutingKeyHeader, base64.StdEncoding.EncodeToString([]byte(shipyardPrivateSecret)))
+		req.Host = ptsConfig.ShipyardHost
+		req.Header.Add("Host", ptsConfig.ShipyardHost)
+		req.Header.Add(ptsConfig.APIRoutingKeyHeader, base64.StdEncoding.EncodeToString([]byte(ptsConfig.ShipyardPrivateSecret)))
 	}
 	req.Header.Add("Authorization", request.Header.Get("Authorization"))
 	req.Header.Add("Content-Type", "application/json")
@@ -0,0 +1,1088 @@
+package fake
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/autoscaling"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/version"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/30x/enrober/pkg/kubeclient"
+)
+
+//objectKey identifies a namespaced object in the fake's store
+type objectKey struct {
+	namespace string
+	name      string
+}
+
+//Client is an in-memory kubernetes API that satisfies kubeclient.Interface.
+//Deployments are rolled out immediately: every create or update makes the replica set and running pods
+//the deployment controller would have made.
+type Client struct {
+	lock sync.Mutex
+
+	resourceVersion uint64
+	podCount        uint64
+
+	namespaces  map[string]*api.Namespace
+	secrets     map[objectKey]*api.Secret
+	configMaps  map[objectKey]*api.ConfigMap
+	deployments map[objectKey]*extensions.Deployment
+	replicaSets map[objectKey]*extensions.ReplicaSet
+	pods        map[objectKey]*api.Pod
+	logs        map[objectKey]string
+	events      map[objectKey]*api.Event
+	hpas        map[objectKey]*autoscaling.HorizontalPodAutoscaler
+
+	//openLogStreams counts the log streams that haven't been closed yet
+	openLogStreams int
+
+	//denied holds the access checks that fail, namespaces are ignored
+	denied map[kubeclient.AccessCheck]bool
+	//accessChecks counts the calls to CanI
+	accessChecks int
+	//used and checked hold the actions the fake has served and those CanI was asked about, namespaces are ignored
+	used    map[kubeclient.AccessCheck]bool
+	checked map[kubeclient.AccessCheck]bool
+
+	podBroadcaster   *watch.Broadcaster
+	eventBroadcaster *watch.Broadcaster
+}
+
+//NewClient creates an empty fake client
+func NewClient() *Client {
+	return &Client{
+		namespaces:       make(map[string]*api.Namespace),
+		secrets:          make(map[objectKey]*api.Secret),
+		configMaps:       make(map[objectKey]*api.ConfigMap),
+		deployments:      make(map[objectKey]*extensions.Deployment),
+		replicaSets:      make(map[objectKey]*extensions.ReplicaSet),
+		pods:             make(map[objectKey]*api.Pod),
+		logs:             make(map[objectKey]string),
+		events:           make(map[objectKey]*api.Event),
+		hpas:             make(map[objectKey]*autoscaling.HorizontalPodAutoscaler),
+		denied:           make(map[kubeclient.AccessCheck]bool),
+		used:             make(map[kubeclient.AccessCheck]bool),
+		checked:          make(map[kubeclient.AccessCheck]bool),
+		podBroadcaster:   watch.NewBroadcaster(100, watch.DropIfChannelFull),
+		eventBroadcaster: watch.NewBroadcaster(100, watch.DropIfChannelFull),
+	}
+}
+
+//SetPodLogs sets the log output returned for every pod whose name starts with podPrefix
+func (c *Client) SetPodLogs(namespace, podPrefix, logs string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.logs[objectKey{namespace, podPrefix}] = logs
+}
+
+//OpenLogStreams is how many log streams have been opened and not closed
+func (c *Client) OpenLogStreams() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.openLogStreams
+}
+
+//RestartContainers counts a restart of every container of the pods whose name starts with podPrefix, as if they had crashed
+func (c *Client) RestartContainers(namespace, podPrefix string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, pod := range c.pods {
+		if key.namespace != namespace || !strings.HasPrefix(key.name, podPrefix) {
+			continue
+		}
+		for i := range pod.Status.ContainerStatuses {
+			pod.Status.ContainerStatuses[i].RestartCount++
+		}
+		c.bumpVersion(&pod.ObjectMeta)
+		c.podBroadcaster.Action(watch.Modified, copyObject(pod))
+	}
+}
+
+//StallContainers leaves every container of a deployment's pods waiting for reason, like an image that can't be pulled.
+//None of the deployment's replicas are available until it's rolled out again.
+func (c *Client) StallContainers(namespace, deployment, reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	dep, ok := c.deployments[objectKey{namespace, deployment}]
+	if !ok {
+		return
+	}
+	for key, pod := range c.pods {
+		if key.namespace != namespace || pod.Labels["component"] != dep.Spec.Selector.MatchLabels["component"] {
+			continue
+		}
+		for i := range pod.Status.ContainerStatuses {
+			pod.Status.ContainerStatuses[i].Ready = false
+			pod.Status.ContainerStatuses[i].State = api.ContainerState{
+				Waiting: &api.ContainerStateWaiting{Reason: reason},
+			}
+		}
+		for i := range pod.Status.Conditions {
+			if pod.Status.Conditions[i].Type == api.PodReady {
+				pod.Status.Conditions[i].Status = api.ConditionFalse
+			}
+		}
+		c.bumpVersion(&pod.ObjectMeta)
+		c.podBroadcaster.Action(watch.Modified, copyObject(pod))
+	}
+	dep.Status.AvailableReplicas = 0
+	dep.Status.UnavailableReplicas = dep.Spec.Replicas
+	c.bumpVersion(&dep.ObjectMeta)
+}
+
+//AddEvent stores an event as if a kubernetes component had recorded it
+func (c *Client) AddEvent(event *api.Event) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.addEvent(copyObject(event).(*api.Event))
+}
+
+//SetAllowed makes CanI allow or deny a verb on a resource in every namespace, everything is allowed by default
+func (c *Client) SetAllowed(check kubeclient.AccessCheck, allowed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	check.Namespace = ""
+	if allowed {
+		delete(c.denied, check)
+	} else {
+		c.denied[check] = true
+	}
+}
+
+//AccessChecks is how many times CanI has been called
+func (c *Client) AccessChecks() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.accessChecks
+}
+
+func (c *Client) ServerVersion() (*version.Info, error) {
+	return &version.Info{GitVersion: "v1.3.0-fake"}, nil
+}
+
+func (c *Client) CanI(check kubeclient.AccessCheck) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.accessChecks++
+	check.Namespace = ""
+	c.checked[check] = true
+	return !c.denied[check], nil
+}
+
+//UncheckedAccess lists the actions the fake has served that CanI was never asked about,
+//so a readiness check would miss enrober losing them
+func (c *Client) UncheckedAccess() []kubeclient.AccessCheck {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	unchecked := []kubeclient.AccessCheck{}
+	for check := range c.used {
+		if !c.checked[check] {
+			unchecked = append(unchecked, check)
+		}
+	}
+	return unchecked
+}
+
+//use records an action the fake has served
+func (c *Client) use(check kubeclient.AccessCheck) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.used[check] = true
+}
+
+func (c *Client) Namespaces() kubeclient.NamespaceInterface {
+	return &namespaces{c}
+}
+
+func (c *Client) Secrets(namespace string) kubeclient.SecretInterface {
+	return &secrets{c, namespace}
+}
+
+func (c *Client) ConfigMaps(namespace string) kubeclient.ConfigMapInterface {
+	return &configMaps{c, namespace}
+}
+
+func (c *Client) Deployments(namespace string) kubeclient.DeploymentInterface {
+	return &deployments{c, namespace}
+}
+
+func (c *Client) ReplicaSets(namespace string) kubeclient.ReplicaSetInterface {
+	return &replicaSets{c, namespace}
+}
+
+func (c *Client) Pods(namespace string) kubeclient.PodInterface {
+	return &pods{c, namespace}
+}
+
+func (c *Client) Events(namespace string) kubeclient.EventInterface {
+	return &events{c, namespace}
+}
+
+func (c *Client) Scales(namespace string) kubeclient.ScaleInterface {
+	return &scales{c, namespace}
+}
+
+func (c *Client) HorizontalPodAutoscalers(namespace string) kubeclient.HorizontalPodAutoscalerInterface {
+	return &hpas{c, namespace}
+}
+
+//newMeta fills in the metadata the API server sets on create.
+//Must be called with the lock held.
+func (c *Client) newMeta(meta *api.ObjectMeta, namespace string) {
+	meta.Namespace = namespace
+	meta.CreationTimestamp = unversioned.Now()
+	meta.Generation = 1
+	c.bumpVersion(meta)
+}
+
+//bumpVersion gives meta the next resource version.
+//Must be called with the lock held.
+func (c *Client) bumpVersion(meta *api.ObjectMeta) {
+	c.resourceVersion++
+	meta.ResourceVersion = strconv.FormatUint(c.resourceVersion, 10)
+}
+
+//checkVersion returns a conflict if the object being written is older than the stored one
+func checkVersion(resource, name, stored, incoming string) error {
+	if incoming != "" && incoming != stored {
+		return errors.NewConflict(api.Resource(resource), name, fmt.Errorf("the object has been modified"))
+	}
+	return nil
+}
+
+//matches checks an object against the label and field selectors of a list
+func matches(opts api.ListOptions, meta api.ObjectMeta) bool {
+	if opts.LabelSelector != nil && !opts.LabelSelector.Matches(labels.Set(meta.Labels)) {
+		return false
+	}
+	if opts.FieldSelector != nil && !opts.FieldSelector.Matches(fields.Set{
+		"metadata.name":      meta.Name,
+		"metadata.namespace": meta.Namespace,
+	}) {
+		return false
+	}
+	return true
+}
+
+//copyObject deep copies an object so callers never share memory with the store
+func copyObject(obj runtime.Object) runtime.Object {
+	copied, err := api.Scheme.Copy(obj)
+	if err != nil {
+		panic(fmt.Sprintf("fake client failed to copy %T: %v", obj, err))
+	}
+	return copied
+}
+
+type namespaces struct {
+	client *Client
+}
+
+func (n *namespaces) Create(item *api.Namespace) (*api.Namespace, error) {
+	n.client.use(kubeclient.AccessCheck{Verb: "create", Resource: "namespaces"})
+	n.client.lock.Lock()
+	defer n.client.lock.Unlock()
+
+	if _, ok := n.client.namespaces[item.Name]; ok {
+		return nil, errors.NewAlreadyExists(api.Resource("namespaces"), item.Name)
+	}
+	stored := copyObject(item).(*api.Namespace)
+	n.client.newMeta(&stored.ObjectMeta, "")
+	stored.Status.Phase = api.NamespaceActive
+	n.client.namespaces[item.Name] = stored
+	return copyObject(stored).(*api.Namespace), nil
+}
+
+func (n *namespaces) Get(name string) (*api.Namespace, error) {
+	n.client.use(kubeclient.AccessCheck{Verb: "get", Resource: "namespaces"})
+	n.client.lock.Lock()
+	defer n.client.lock.Unlock()
+
+	stored, ok := n.client.namespaces[name]
+	if !ok {
+		return nil, errors.NewNotFound(api.Resource("namespaces"), name)
+	}
+	return copyObject(stored).(*api.Namespace), nil
+}
+
+func (n *namespaces) List(opts api.ListOptions) (*api.NamespaceList, error) {
+	n.client.use(kubeclient.AccessCheck{Verb: "list", Resource: "namespaces"})
+	n.client.lock.Lock()
+	defer n.client.lock.Unlock()
+
+	list := &api.NamespaceList{}
+	for _, stored := range n.client.namespaces {
+		if matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*api.Namespace))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(n.client.resourceVersion, 10)
+	return list, nil
+}
+
+func (n *namespaces) Update(item *api.Namespace) (*api.Namespace, error) {
+	n.client.use(kubeclient.AccessCheck{Verb: "update", Resource: "namespaces"})
+	n.client.lock.Lock()
+	defer n.client.lock.Unlock()
+
+	stored, ok := n.client.namespaces[item.Name]
+	if !ok {
+		return nil, errors.NewNotFound(api.Resource("namespaces"), item.Name)
+	}
+	if err := checkVersion("namespaces", item.Name, stored.ResourceVersion, item.ResourceVersion); err != nil {
+		return nil, err
+	}
+	updated := copyObject(item).(*api.Namespace)
+	updated.CreationTimestamp = stored.CreationTimestamp
+	n.client.bumpVersion(&updated.ObjectMeta)
+	n.client.namespaces[item.Name] = updated
+	return copyObject(updated).(*api.Namespace), nil
+}
+
+//Delete removes the namespace and everything in it right away
+func (n *namespaces) Delete(name string) error {
+	n.client.use(kubeclient.AccessCheck{Verb: "delete", Resource: "namespaces"})
+	n.client.lock.Lock()
+	defer n.client.lock.Unlock()
+
+	if _, ok := n.client.namespaces[name]; !ok {
+		return errors.NewNotFound(api.Resource("namespaces"), name)
+	}
+	delete(n.client.namespaces, name)
+
+	for key := range n.client.secrets {
+		if key.namespace == name {
+			delete(n.client.secrets, key)
+		}
+	}
+	for key := range n.client.configMaps {
+		if key.namespace == name {
+			delete(n.client.configMaps, key)
+		}
+	}
+	for key := range n.client.deployments {
+		if key.namespace == name {
+			delete(n.client.deployments, key)
+		}
+	}
+	for key := range n.client.replicaSets {
+		if key.namespace == name {
+			delete(n.client.replicaSets, key)
+		}
+	}
+	for key, pod := range n.client.pods {
+		if key.namespace == name {
+			n.client.deletePod(key, pod)
+		}
+	}
+	for key := range n.client.events {
+		if key.namespace == name {
+			delete(n.client.events, key)
+		}
+	}
+	for key := range n.client.hpas {
+		if key.namespace == name {
+			delete(n.client.hpas, key)
+		}
+	}
+	return nil
+}
+
+type secrets struct {
+	client    *Client
+	namespace string
+}
+
+func (s *secrets) Create(secret *api.Secret) (*api.Secret, error) {
+	s.client.use(kubeclient.AccessCheck{Verb: "create", Resource: "secrets"})
+	s.client.lock.Lock()
+	defer s.client.lock.Unlock()
+
+	if _, ok := s.client.namespaces[s.namespace]; !ok {
+		return nil, errors.NewNotFound(api.Resource("namespaces"), s.namespace)
+	}
+	key := objectKey{s.namespace, secret.Name}
+	if _, ok := s.client.secrets[key]; ok {
+		return nil, errors.NewAlreadyExists(api.Resource("secrets"), secret.Name)
+	}
+	stored := copyObject(secret).(*api.Secret)
+	s.client.newMeta(&stored.ObjectMeta, s.namespace)
+	s.client.secrets[key] = stored
+	return copyObject(stored).(*api.Secret), nil
+}
+
+func (s *secrets) Get(name string) (*api.Secret, error) {
+	s.client.use(kubeclient.AccessCheck{Verb: "get", Resource: "secrets"})
+	s.client.lock.Lock()
+	defer s.client.lock.Unlock()
+
+	stored, ok := s.client.secrets[objectKey{s.namespace, name}]
+	if !ok {
+		return nil, errors.NewNotFound(api.Resource("secrets"), name)
+	}
+	return copyObject(stored).(*api.Secret), nil
+}
+
+func (s *secrets) List(opts api.ListOptions) (*api.SecretList, error) {
+	s.client.use(kubeclient.AccessCheck{Verb: "list", Resource: "secrets"})
+	s.client.lock.Lock()
+	defer s.client.lock.Unlock()
+
+	list := &api.SecretList{}
+	for key, stored := range s.client.secrets {
+		if (s.namespace == api.NamespaceAll || key.namespace == s.namespace) && matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*api.Secret))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(s.client.resourceVersion, 10)
+	return list, nil
+}
+
+func (s *secrets) Update(secret *api.Secret) (*api.Secret, error) {
+	s.client.use(kubeclient.AccessCheck{Verb: "update", Resource: "secrets"})
+	s.client.lock.Lock()
+	defer s.client.lock.Unlock()
+
+	key := objectKey{s.namespace, secret.Name}
+	stored, ok := s.client.secrets[key]
+	if !ok {
+		return nil, errors.NewNotFound(api.Resource("secrets"), secret.Name)
+	}
+	if err := checkVersion("secrets", secret.Name, stored.ResourceVersion, secret.ResourceVersion); err != nil {
+		return nil, err
+	}
+	updated := copyObject(secret).(*api.Secret)
+	updated.Namespace = s.namespace
+	updated.CreationTimestamp = stored.CreationTimestamp
+	s.client.bumpVersion(&updated.ObjectMeta)
+	s.client.secrets[key] = updated
+	return copyObject(updated).(*api.Secret), nil
+}
+
+func (s *secrets) Delete(name string) error {
+	s.client.use(kubeclient.AccessCheck{Verb: "delete", Resource: "secrets"})
+	s.client.lock.Lock()
+	defer s.client.lock.Unlock()
+
+	key := objectKey{s.namespace, name}
+	if _, ok := s.client.secrets[key]; !ok {
+		return errors.NewNotFound(api.Resource("secrets"), name)
+	}
+	delete(s.client.secrets, key)
+	return nil
+}
+
+type configMaps struct {
+	client    *Client
+	namespace string
+}
+
+func (c *configMaps) Create(configMap *api.ConfigMap) (*api.ConfigMap, error) {
+	c.client.use(kubeclient.AccessCheck{Verb: "create", Resource: "configmaps"})
+	c.client.lock.Lock()
+	defer c.client.lock.Unlock()
+
+	if _, ok := c.client.namespaces[c.namespace]; !ok {
+		return nil, errors.NewNotFound(api.Resource("namespaces"), c.namespace)
+	}
+	key := objectKey{c.namespace, configMap.Name}
+	if _, ok := c.client.configMaps[key]; ok {
+		return nil, errors.NewAlreadyExists(api.Resource("configmaps"), configMap.Name)
+	}
+	stored := copyObject(configMap).(*api.ConfigMap)
+	c.client.newMeta(&stored.ObjectMeta, c.namespace)
+	c.client.configMaps[key] = stored
+	return copyObject(stored).(*api.ConfigMap), nil
+}
+
+func (c *configMaps) Get(name string) (*api.ConfigMap, error) {
+	c.client.use(kubeclient.AccessCheck{Verb: "get", Resource: "configmaps"})
+	c.client.lock.Lock()
+	defer c.client.lock.Unlock()
+
+	stored, ok := c.client.configMaps[objectKey{c.namespace, name}]
+	if !ok {
+		return nil, errors.NewNotFound(api.Resource("configmaps"), name)
+	}
+	return copyObject(stored).(*api.ConfigMap), nil
+}
+
+func (c *configMaps) List(opts api.ListOptions) (*api.ConfigMapList, error) {
+	c.client.use(kubeclient.AccessCheck{Verb: "list", Resource: "configmaps"})
+	c.client.lock.Lock()
+	defer c.client.lock.Unlock()
+
+	list := &api.ConfigMapList{}
+	for key, stored := range c.client.configMaps {
+		if (c.namespace == api.NamespaceAll || key.namespace == c.namespace) && matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*api.ConfigMap))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(c.client.resourceVersion, 10)
+	return list, nil
+}
+
+func (c *configMaps) Update(configMap *api.ConfigMap) (*api.ConfigMap, error) {
+	c.client.use(kubeclient.AccessCheck{Verb: "update", Resource: "configmaps"})
+	c.client.lock.Lock()
+	defer c.client.lock.Unlock()
+
+	key := objectKey{c.namespace, configMap.Name}
+	stored, ok := c.client.configMaps[key]
+	if !ok {
+		return nil, errors.NewNotFound(api.Resource("configmaps"), configMap.Name)
+	}
+	if err := checkVersion("configmaps", configMap.Name, stored.ResourceVersion, configMap.ResourceVersion); err != nil {
+		return nil, err
+	}
+	updated := copyObject(configMap).(*api.ConfigMap)
+	updated.Namespace = c.namespace
+	updated.CreationTimestamp = stored.CreationTimestamp
+	c.client.bumpVersion(&updated.ObjectMeta)
+	c.client.configMaps[key] = updated
+	return copyObject(updated).(*api.ConfigMap), nil
+}
+
+func (c *configMaps) Delete(name string) error {
+	c.client.use(kubeclient.AccessCheck{Verb: "delete", Resource: "configmaps"})
+	c.client.lock.Lock()
+	defer c.client.lock.Unlock()
+
+	key := objectKey{c.namespace, name}
+	if _, ok := c.client.configMaps[key]; !ok {
+		return errors.NewNotFound(api.Resource("configmaps"), name)
+	}
+	delete(c.client.configMaps, key)
+	return nil
+}
+
+type deployments struct {
+	client    *Client
+	namespace string
+}
+
+func (d *deployments) Create(deployment *extensions.Deployment) (*extensions.Deployment, error) {
+	d.client.use(kubeclient.AccessCheck{Verb: "create", Group: "extensions", Resource: "deployments"})
+	d.client.lock.Lock()
+	defer d.client.lock.Unlock()
+
+	if _, ok := d.client.namespaces[d.namespace]; !ok {
+		return nil, errors.NewNotFound(api.Resource("namespaces"), d.namespace)
+	}
+	key := objectKey{d.namespace, deployment.Name}
+	if _, ok := d.client.deployments[key]; ok {
+		return nil, errors.NewAlreadyExists(extensions.Resource("deployments"), deployment.Name)
+	}
+	stored := copyObject(deployment).(*extensions.Deployment)
+	d.client.newMeta(&stored.ObjectMeta, d.namespace)
+
+	//The API server defaults the deployment's labels from its template
+	if len(stored.Labels) == 0 {
+		stored.Labels = stored.Spec.Template.Labels
+	}
+
+	d.client.deployments[key] = stored
+	d.client.rollout(stored)
+	return copyObject(stored).(*extensions.Deployment), nil
+}
+
+func (d *deployments) Get(name string) (*extensions.Deployment, error) {
+	d.client.use(kubeclient.AccessCheck{Verb: "get", Group: "extensions", Resource: "deployments"})
+	d.client.lock.Lock()
+	defer d.client.lock.Unlock()
+
+	stored, ok := d.client.deployments[objectKey{d.namespace, name}]
+	if !ok {
+		return nil, errors.NewNotFound(extensions.Resource("deployments"), name)
+	}
+	return copyObject(stored).(*extensions.Deployment), nil
+}
+
+func (d *deployments) List(opts api.ListOptions) (*extensions.DeploymentList, error) {
+	d.client.use(kubeclient.AccessCheck{Verb: "list", Group: "extensions", Resource: "deployments"})
+	d.client.lock.Lock()
+	defer d.client.lock.Unlock()
+
+	list := &extensions.DeploymentList{}
+	for key, stored := range d.client.deployments {
+		if (d.namespace == api.NamespaceAll || key.namespace == d.namespace) && matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*extensions.Deployment))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(d.client.resourceVersion, 10)
+	return list, nil
+}
+
+func (d *deployments) Update(deployment *extensions.Deployment) (*extensions.Deployment, error) {
+	d.client.use(kubeclient.AccessCheck{Verb: "update", Group: "extensions", Resource: "deployments"})
+	d.client.lock.Lock()
+	defer d.client.lock.Unlock()
+
+	key := objectKey{d.namespace, deployment.Name}
+	stored, ok := d.client.deployments[key]
+	if !ok {
+		return nil, errors.NewNotFound(extensions.Resource("deployments"), deployment.Name)
+	}
+	if err := checkVersion("deployments", deployment.Name, stored.ResourceVersion, deployment.ResourceVersion); err != nil {
+		return nil, err
+	}
+	updated := copyObject(deployment).(*extensions.Deployment)
+	updated.Namespace = d.namespace
+	updated.CreationTimestamp = stored.CreationTimestamp
+	updated.Generation = stored.Generation + 1
+	d.client.bumpVersion(&updated.ObjectMeta)
+	d.client.deployments[key] = updated
+	d.client.rollout(updated)
+	return copyObject(updated).(*extensions.Deployment), nil
+}
+
+//Delete only removes the deployment, like the 1.3 API server its replica sets and pods are left behind
+func (d *deployments) Delete(name string, options *api.DeleteOptions) error {
+	d.client.use(kubeclient.AccessCheck{Verb: "delete", Group: "extensions", Resource: "deployments"})
+	d.client.lock.Lock()
+	defer d.client.lock.Unlock()
+
+	key := objectKey{d.namespace, name}
+	if _, ok := d.client.deployments[key]; !ok {
+		return errors.NewNotFound(extensions.Resource("deployments"), name)
+	}
+	delete(d.client.deployments, key)
+	return nil
+}
+
+type replicaSets struct {
+	client    *Client
+	namespace string
+}
+
+func (r *replicaSets) List(opts api.ListOptions) (*extensions.ReplicaSetList, error) {
+	r.client.use(kubeclient.AccessCheck{Verb: "list", Group: "extensions", Resource: "replicasets"})
+	r.client.lock.Lock()
+	defer r.client.lock.Unlock()
+
+	list := &extensions.ReplicaSetList{}
+	for key, stored := range r.client.replicaSets {
+		if (r.namespace == api.NamespaceAll || key.namespace == r.namespace) && matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*extensions.ReplicaSet))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(r.client.resourceVersion, 10)
+	return list, nil
+}
+
+func (r *replicaSets) Delete(name string, options *api.DeleteOptions) error {
+	r.client.use(kubeclient.AccessCheck{Verb: "delete", Group: "extensions", Resource: "replicasets"})
+	r.client.lock.Lock()
+	defer r.client.lock.Unlock()
+
+	key := objectKey{r.namespace, name}
+	if _, ok := r.client.replicaSets[key]; !ok {
+		return errors.NewNotFound(extensions.Resource("replicasets"), name)
+	}
+	delete(r.client.replicaSets, key)
+	return nil
+}
+
+type pods struct {
+	client    *Client
+	namespace string
+}
+
+func (p *pods) List(opts api.ListOptions) (*api.PodList, error) {
+	p.client.use(kubeclient.AccessCheck{Verb: "list", Resource: "pods"})
+	p.client.lock.Lock()
+	defer p.client.lock.Unlock()
+
+	list := &api.PodList{}
+	for key, stored := range p.client.pods {
+		if (p.namespace == api.NamespaceAll || key.namespace == p.namespace) && matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*api.Pod))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(p.client.resourceVersion, 10)
+	return list, nil
+}
+
+func (p *pods) Delete(name string, options *api.DeleteOptions) error {
+	p.client.use(kubeclient.AccessCheck{Verb: "delete", Resource: "pods"})
+	p.client.lock.Lock()
+	defer p.client.lock.Unlock()
+
+	key := objectKey{p.namespace, name}
+	pod, ok := p.client.pods[key]
+	if !ok {
+		return errors.NewNotFound(api.Resource("pods"), name)
+	}
+	p.client.deletePod(key, pod)
+	return nil
+}
+
+//Watch only reports pod events that happen after it was called
+func (p *pods) Watch(opts api.ListOptions) (watch.Interface, error) {
+	p.client.use(kubeclient.AccessCheck{Verb: "watch", Resource: "pods"})
+	return watch.Filter(p.client.podBroadcaster.Watch(), func(in watch.Event) (watch.Event, bool) {
+		pod, ok := in.Object.(*api.Pod)
+		if !ok {
+			return in, false
+		}
+		return in, pod.Namespace == p.namespace && matches(opts, pod.ObjectMeta)
+	}), nil
+}
+
+//GetLogs returns a request whose stream is the logs set with SetPodLogs
+func (p *pods) GetLogs(name string, opts *api.PodLogOptions) *restclient.Request {
+	p.client.use(kubeclient.AccessCheck{Verb: "get", Resource: "pods", Subresource: "log"})
+	p.client.lock.Lock()
+	defer p.client.lock.Unlock()
+
+	httpClient := &logClient{client: p.client, statusCode: http.StatusNotFound, body: fmt.Sprintf("pods %q not found", name)}
+
+	if _, ok := p.client.pods[objectKey{p.namespace, name}]; ok {
+		httpClient.statusCode = http.StatusOK
+		httpClient.body = ""
+		for key, logs := range p.client.logs {
+			if key.namespace == p.namespace && strings.HasPrefix(name, key.name) {
+				httpClient.body = tailLines(logs, opts.TailLines)
+				break
+			}
+		}
+	}
+
+	return restclient.NewRequest(httpClient, "GET", &url.URL{Scheme: "http", Host: "fake"}, "", restclient.ContentConfig{}, restclient.Serializers{Decoder: api.Codecs.UniversalDecoder()}, nil, nil)
+}
+
+//tailLines keeps only the last tail lines of logs
+func tailLines(logs string, tail *int64) string {
+	if tail == nil {
+		return logs
+	}
+	lines := strings.SplitAfter(logs, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if int64(len(lines)) > *tail {
+		lines = lines[int64(len(lines))-*tail:]
+	}
+	return strings.Join(lines, "")
+}
+
+//logClient answers every request with a fixed response
+type logClient struct {
+	client     *Client
+	statusCode int
+	body       string
+}
+
+func (l *logClient) Do(req *http.Request) (*http.Response, error) {
+	body := ioutil.NopCloser(strings.NewReader(l.body))
+	if l.statusCode == http.StatusOK {
+		l.client.lock.Lock()
+		l.client.openLogStreams++
+		l.client.lock.Unlock()
+		body = &logStream{Reader: strings.NewReader(l.body), client: l.client}
+	}
+	return &http.Response{
+		StatusCode: l.statusCode,
+		Status:     http.StatusText(l.statusCode),
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       body,
+		Request:    req,
+	}, nil
+}
+
+//logStream is the body of a log stream, closing it is counted once
+type logStream struct {
+	io.Reader
+	client *Client
+	once   sync.Once
+}
+
+func (l *logStream) Close() error {
+	l.once.Do(func() {
+		l.client.lock.Lock()
+		l.client.openLogStreams--
+		l.client.lock.Unlock()
+	})
+	return nil
+}
+
+//deletePod removes a pod and tells watchers.
+//Must be called with the lock held.
+func (c *Client) deletePod(key objectKey, pod *api.Pod) {
+	delete(c.pods, key)
+	c.podBroadcaster.Action(watch.Deleted, copyObject(pod))
+}
+
+//scales only scales deployments
+type scales struct {
+	client    *Client
+	namespace string
+}
+
+func (s *scales) Get(kind string, name string) (*extensions.Scale, error) {
+	s.client.use(kubeclient.AccessCheck{Verb: "get", Group: "extensions", Resource: "deployments", Subresource: "scale"})
+	s.client.lock.Lock()
+	defer s.client.lock.Unlock()
+
+	dep, err := s.deployment(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	return deploymentScale(dep), nil
+}
+
+//Update ignores the resource version of scale when it's empty, like the API server
+func (s *scales) Update(kind string, scale *extensions.Scale) (*extensions.Scale, error) {
+	s.client.use(kubeclient.AccessCheck{Verb: "update", Group: "extensions", Resource: "deployments", Subresource: "scale"})
+	s.client.lock.Lock()
+	defer s.client.lock.Unlock()
+
+	dep, err := s.deployment(kind, scale.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("deployments", scale.Name, dep.ResourceVersion, scale.ResourceVersion); err != nil {
+		return nil, err
+	}
+
+	dep.Spec.Replicas = scale.Spec.Replicas
+	dep.Generation++
+	s.client.bumpVersion(&dep.ObjectMeta)
+	s.client.rollout(dep)
+	return deploymentScale(dep), nil
+}
+
+//deployment is the stored deployment a scale is for.
+//Must be called with the lock held.
+func (s *scales) deployment(kind, name string) (*extensions.Deployment, error) {
+	if kind != "Deployment" {
+		return nil, errors.NewBadRequest(fmt.Sprintf("fake client can't scale a %s", kind))
+	}
+	dep, ok := s.client.deployments[objectKey{s.namespace, name}]
+	if !ok {
+		return nil, errors.NewNotFound(extensions.Resource("deployments"), name)
+	}
+	return dep, nil
+}
+
+//deploymentScale is the scale subresource of a deployment
+func deploymentScale(dep *extensions.Deployment) *extensions.Scale {
+	selector := &unversioned.LabelSelector{
+		MatchLabels: map[string]string{},
+	}
+	for k, v := range dep.Spec.Selector.MatchLabels {
+		selector.MatchLabels[k] = v
+	}
+
+	return &extensions.Scale{
+		ObjectMeta: api.ObjectMeta{
+			Name:              dep.Name,
+			Namespace:         dep.Namespace,
+			ResourceVersion:   dep.ResourceVersion,
+			CreationTimestamp: dep.CreationTimestamp,
+		},
+		Spec: extensions.ScaleSpec{
+			Replicas: dep.Spec.Replicas,
+		},
+		Status: extensions.ScaleStatus{
+			Replicas: dep.Status.Replicas,
+			Selector: selector,
+		},
+	}
+}
+
+//hpas doesn't autoscale, the status only reports the replicas of the target deployment and what the bounds would make of them
+type hpas struct {
+	client    *Client
+	namespace string
+}
+
+func (h *hpas) Create(hpa *autoscaling.HorizontalPodAutoscaler) (*autoscaling.HorizontalPodAutoscaler, error) {
+	h.client.use(kubeclient.AccessCheck{Verb: "create", Group: "autoscaling", Resource: "horizontalpodautoscalers"})
+	h.client.lock.Lock()
+	defer h.client.lock.Unlock()
+
+	key := objectKey{h.namespace, hpa.Name}
+	if _, ok := h.client.hpas[key]; ok {
+		return nil, errors.NewAlreadyExists(autoscaling.Resource("horizontalpodautoscalers"), hpa.Name)
+	}
+	created := copyObject(hpa).(*autoscaling.HorizontalPodAutoscaler)
+	h.client.newMeta(&created.ObjectMeta, h.namespace)
+	h.client.hpaStatus(created)
+	h.client.hpas[key] = created
+	return copyObject(created).(*autoscaling.HorizontalPodAutoscaler), nil
+}
+
+func (h *hpas) Get(name string) (*autoscaling.HorizontalPodAutoscaler, error) {
+	h.client.use(kubeclient.AccessCheck{Verb: "get", Group: "autoscaling", Resource: "horizontalpodautoscalers"})
+	h.client.lock.Lock()
+	defer h.client.lock.Unlock()
+
+	stored, ok := h.client.hpas[objectKey{h.namespace, name}]
+	if !ok {
+		return nil, errors.NewNotFound(autoscaling.Resource("horizontalpodautoscalers"), name)
+	}
+	return copyObject(stored).(*autoscaling.HorizontalPodAutoscaler), nil
+}
+
+func (h *hpas) List(opts api.ListOptions) (*autoscaling.HorizontalPodAutoscalerList, error) {
+	h.client.use(kubeclient.AccessCheck{Verb: "list", Group: "autoscaling", Resource: "horizontalpodautoscalers"})
+	h.client.lock.Lock()
+	defer h.client.lock.Unlock()
+
+	list := &autoscaling.HorizontalPodAutoscalerList{}
+	for key, stored := range h.client.hpas {
+		if (h.namespace == api.NamespaceAll || key.namespace == h.namespace) && matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*autoscaling.HorizontalPodAutoscaler))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(h.client.resourceVersion, 10)
+	return list, nil
+}
+
+func (h *hpas) Update(hpa *autoscaling.HorizontalPodAutoscaler) (*autoscaling.HorizontalPodAutoscaler, error) {
+	h.client.use(kubeclient.AccessCheck{Verb: "update", Group: "autoscaling", Resource: "horizontalpodautoscalers"})
+	h.client.lock.Lock()
+	defer h.client.lock.Unlock()
+
+	key := objectKey{h.namespace, hpa.Name}
+	stored, ok := h.client.hpas[key]
+	if !ok {
+		return nil, errors.NewNotFound(autoscaling.Resource("horizontalpodautoscalers"), hpa.Name)
+	}
+	if err := checkVersion("horizontalpodautoscalers", hpa.Name, stored.ResourceVersion, hpa.ResourceVersion); err != nil {
+		return nil, err
+	}
+	updated := copyObject(hpa).(*autoscaling.HorizontalPodAutoscaler)
+	updated.Namespace = h.namespace
+	updated.CreationTimestamp = stored.CreationTimestamp
+	h.client.bumpVersion(&updated.ObjectMeta)
+	h.client.hpaStatus(updated)
+	h.client.hpas[key] = updated
+	return copyObject(updated).(*autoscaling.HorizontalPodAutoscaler), nil
+}
+
+func (h *hpas) Delete(name string, options *api.DeleteOptions) error {
+	h.client.use(kubeclient.AccessCheck{Verb: "delete", Group: "autoscaling", Resource: "horizontalpodautoscalers"})
+	h.client.lock.Lock()
+	defer h.client.lock.Unlock()
+
+	key := objectKey{h.namespace, name}
+	if _, ok := h.client.hpas[key]; !ok {
+		return errors.NewNotFound(autoscaling.Resource("horizontalpodautoscalers"), name)
+	}
+	delete(h.client.hpas, key)
+	return nil
+}
+
+//hpaStatus fills in the status of an autoscaler from its target deployment.
+//Must be called with the lock held.
+func (c *Client) hpaStatus(hpa *autoscaling.HorizontalPodAutoscaler) {
+	hpa.Status = autoscaling.HorizontalPodAutoscalerStatus{}
+
+	dep, ok := c.deployments[objectKey{hpa.Namespace, hpa.Spec.ScaleTargetRef.Name}]
+	if !ok {
+		return
+	}
+
+	hpa.Status.CurrentReplicas = dep.Status.Replicas
+	hpa.Status.DesiredReplicas = dep.Spec.Replicas
+	if hpa.Spec.MinReplicas != nil && hpa.Status.DesiredReplicas < *hpa.Spec.MinReplicas {
+		hpa.Status.DesiredReplicas = *hpa.Spec.MinReplicas
+	}
+	if hpa.Status.DesiredReplicas > hpa.Spec.MaxReplicas {
+		hpa.Status.DesiredReplicas = hpa.Spec.MaxReplicas
+	}
+}
+
+type events struct {
+	client    *Client
+	namespace string
+}
+
+func (e *events) List(opts api.ListOptions) (*api.EventList, error) {
+	e.client.use(kubeclient.AccessCheck{Verb: "list", Resource: "events"})
+	e.client.lock.Lock()
+	defer e.client.lock.Unlock()
+
+	list := &api.EventList{}
+	for key, stored := range e.client.events {
+		if (e.namespace == api.NamespaceAll || key.namespace == e.namespace) && matches(opts, stored.ObjectMeta) {
+			list.Items = append(list.Items, *copyObject(stored).(*api.Event))
+		}
+	}
+	list.ResourceVersion = strconv.FormatUint(e.client.resourceVersion, 10)
+	return list, nil
+}
+
+//Watch only reports events recorded after it was called
+func (e *events) Watch(opts api.ListOptions) (watch.Interface, error) {
+	e.client.use(kubeclient.AccessCheck{Verb: "watch", Resource: "events"})
+	return watch.Filter(e.client.eventBroadcaster.Watch(), func(in watch.Event) (watch.Event, bool) {
+		event, ok := in.Object.(*api.Event)
+		if !ok {
+			return in, false
+		}
+		return in, (e.namespace == api.NamespaceAll || event.Namespace == e.namespace) && matches(opts, event.ObjectMeta)
+	}), nil
+}
+
+//recordEvent records a Normal event about an object the way the controllers would.
+//Must be called with the lock held.
+func (c *Client) recordEvent(namespace, kind, name, reason, message string) {
+	c.addEvent(&api.Event{
+		ObjectMeta: api.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%d", name, c.resourceVersion+1),
+			Namespace: namespace,
+		},
+		InvolvedObject: api.ObjectReference{
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+		},
+		Reason:  reason,
+		Message: message,
+		Type:    api.EventTypeNormal,
+		Count:   1,
+	})
+}
+
+//addEvent stores an event and tells the watchers about it.
+//Must be called with the lock held.
+func (c *Client) addEvent(event *api.Event) {
+	c.newMeta(&event.ObjectMeta, event.Namespace)
+	if event.FirstTimestamp.IsZero() {
+		event.FirstTimestamp = event.CreationTimestamp
+	}
+	if event.LastTimestamp.IsZero() {
+		event.LastTimestamp = event.FirstTimestamp
+	}
+	c.events[objectKey{event.Namespace, event.Name}] = event
+	c.eventBroadcaster.Action(watch.Added, copyObject(event))
+}
@@ -0,0 +1,200 @@
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/watch"
+
+	deploymentutil "k8s.io/kubernetes/pkg/util/deployment"
+	podutil "k8s.io/kubernetes/pkg/util/pod"
+)
+
+//rollout does what the deployment controller would do for a new or changed deployment, all at once.
+//The replica set matching the template becomes the newest revision and owns every pod, older ones are scaled to zero
+//and trimmed to the revision history limit.
+//Must be called with the lock held.
+func (c *Client) rollout(dep *extensions.Deployment) {
+	template := dep.Spec.Template
+	hash := fmt.Sprintf("%d", podutil.GetPodTemplateSpecHash(template))
+
+	var owned []*extensions.ReplicaSet
+	var newRS *extensions.ReplicaSet
+	var maxRevision int64
+	for key, rs := range c.replicaSets {
+		if key.namespace != dep.Namespace || rs.Labels["component"] != dep.Spec.Selector.MatchLabels["component"] {
+			continue
+		}
+		owned = append(owned, rs)
+		if revision, _ := deploymentutil.Revision(rs); revision > maxRevision {
+			maxRevision = revision
+		}
+		if rs.Labels[extensions.DefaultDeploymentUniqueLabelKey] == hash {
+			newRS = rs
+		}
+	}
+
+	currentRevision, _ := strconv.ParseInt(dep.Annotations[deploymentutil.RevisionAnnotation], 10, 64)
+
+	if newRS == nil {
+		newRS = &extensions.ReplicaSet{
+			ObjectMeta: api.ObjectMeta{
+				Name:        dep.Name + "-" + hash,
+				Labels:      copyLabels(template.Labels, hash),
+				Annotations: map[string]string{},
+			},
+			Spec: extensions.ReplicaSetSpec{
+				Template: copyTemplate(template),
+			},
+		}
+		newRS.Spec.Template.Labels = copyLabels(template.Labels, hash)
+		c.newMeta(&newRS.ObjectMeta, dep.Namespace)
+		c.replicaSets[objectKey{dep.Namespace, newRS.Name}] = newRS
+		owned = append(owned, newRS)
+	}
+
+	//Only a template change (or a rollback) makes a new revision
+	if revision, _ := deploymentutil.Revision(newRS); revision == 0 || revision != currentRevision {
+		newRS.Annotations[deploymentutil.RevisionAnnotation] = strconv.FormatInt(maxRevision+1, 10)
+		if cause, ok := dep.Annotations["kubernetes.io/change-cause"]; ok {
+			newRS.Annotations["kubernetes.io/change-cause"] = cause
+		}
+		c.bumpVersion(&newRS.ObjectMeta)
+	}
+
+	if dep.Annotations == nil {
+		dep.Annotations = map[string]string{}
+	}
+	dep.Annotations[deploymentutil.RevisionAnnotation] = newRS.Annotations[deploymentutil.RevisionAnnotation]
+
+	//Scale everything but the new replica set down
+	for _, rs := range owned {
+		replicas := int32(0)
+		if rs == newRS {
+			replicas = dep.Spec.Replicas
+		}
+		if replicas != rs.Spec.Replicas {
+			c.recordEvent(dep.Namespace, "Deployment", dep.Name, "ScalingReplicaSet",
+				fmt.Sprintf("Scaled replica set %s to %d", rs.Name, replicas))
+		}
+		c.scaleReplicaSet(rs, replicas)
+	}
+
+	c.trimHistory(dep, owned, newRS)
+
+	dep.Status = extensions.DeploymentStatus{
+		ObservedGeneration: dep.Generation,
+		Replicas:           dep.Spec.Replicas,
+		UpdatedReplicas:    dep.Spec.Replicas,
+		AvailableReplicas:  dep.Spec.Replicas,
+	}
+}
+
+//scaleReplicaSet creates or deletes running pods until the replica set owns replicas of them.
+//Must be called with the lock held.
+func (c *Client) scaleReplicaSet(rs *extensions.ReplicaSet, replicas int32) {
+	var rsPods []objectKey
+	for key, pod := range c.pods {
+		if key.namespace == rs.Namespace && pod.Labels[extensions.DefaultDeploymentUniqueLabelKey] == rs.Labels[extensions.DefaultDeploymentUniqueLabelKey] {
+			rsPods = append(rsPods, key)
+		}
+	}
+
+	for int32(len(rsPods)) > replicas {
+		key := rsPods[len(rsPods)-1]
+		c.deletePod(key, c.pods[key])
+		rsPods = rsPods[:len(rsPods)-1]
+	}
+
+	for int32(len(rsPods)) < replicas {
+		c.podCount++
+		template := copyTemplate(rs.Spec.Template)
+		pod := &api.Pod{
+			ObjectMeta: template.ObjectMeta,
+			Spec:       template.Spec,
+		}
+		pod.Name = fmt.Sprintf("%s-%d", rs.Name, c.podCount)
+		c.newMeta(&pod.ObjectMeta, rs.Namespace)
+		pod.Spec.NodeName = "fake-node"
+		pod.Status = runningStatus(pod)
+
+		key := objectKey{rs.Namespace, pod.Name}
+		c.pods[key] = pod
+		c.recordEvent(rs.Namespace, "ReplicaSet", rs.Name, "SuccessfulCreate", "Created pod: "+pod.Name)
+		c.podBroadcaster.Action(watch.Added, copyObject(pod))
+		rsPods = append(rsPods, key)
+	}
+
+	rs.Spec.Replicas = replicas
+	rs.Status.Replicas = replicas
+}
+
+//trimHistory deletes the oldest scaled down replica sets beyond the deployment's revision history limit.
+//Must be called with the lock held.
+func (c *Client) trimHistory(dep *extensions.Deployment, owned []*extensions.ReplicaSet, newRS *extensions.ReplicaSet) {
+	if dep.Spec.RevisionHistoryLimit == nil {
+		return
+	}
+
+	var old []*extensions.ReplicaSet
+	for _, rs := range owned {
+		if rs != newRS {
+			old = append(old, rs)
+		}
+	}
+	sort.Sort(byRevision(old))
+
+	for int32(len(old)) > *dep.Spec.RevisionHistoryLimit {
+		delete(c.replicaSets, objectKey{old[0].Namespace, old[0].Name})
+		old = old[1:]
+	}
+}
+
+//runningStatus is the status of a pod whose containers all started and are ready
+func runningStatus(pod *api.Pod) api.PodStatus {
+	status := api.PodStatus{
+		Phase: api.PodRunning,
+		Conditions: []api.PodCondition{
+			{Type: api.PodReady, Status: api.ConditionTrue},
+		},
+	}
+	for _, container := range pod.Spec.Containers {
+		status.ContainerStatuses = append(status.ContainerStatuses, api.ContainerStatus{
+			Name:  container.Name,
+			Image: container.Image,
+			Ready: true,
+			State: api.ContainerState{
+				Running: &api.ContainerStateRunning{StartedAt: pod.CreationTimestamp},
+			},
+		})
+	}
+	return status
+}
+
+//copyTemplate deep copies a pod template spec
+func copyTemplate(template api.PodTemplateSpec) api.PodTemplateSpec {
+	return copyObject(&api.PodTemplate{Template: template}).(*api.PodTemplate).Template
+}
+
+//copyLabels copies labels and adds the pod-template-hash label
+func copyLabels(labels map[string]string, hash string) map[string]string {
+	copied := map[string]string{}
+	for k, v := range labels {
+		copied[k] = v
+	}
+	copied[extensions.DefaultDeploymentUniqueLabelKey] = hash
+	return copied
+}
+
+type byRevision []*extensions.ReplicaSet
+
+func (r byRevision) Len() int      { return len(r) }
+func (r byRevision) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byRevision) Less(i, j int) bool {
+	revisionI, _ := deploymentutil.Revision(r[i])
+	revisionJ, _ := deploymentutil.Revision(r[j])
+	return revisionI < revisionJ
+}
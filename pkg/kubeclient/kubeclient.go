@@ -0,0 +1,238 @@
+package kubeclient
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/autoscaling"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	"k8s.io/kubernetes/pkg/version"
+	"k8s.io/kubernetes/pkg/watch"
+
+	k8sClient "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+//Interface is the subset of the kubernetes API that enrober uses
+type Interface interface {
+	Namespaces() NamespaceInterface
+	Secrets(namespace string) SecretInterface
+	ConfigMaps(namespace string) ConfigMapInterface
+	Deployments(namespace string) DeploymentInterface
+	ReplicaSets(namespace string) ReplicaSetInterface
+	Pods(namespace string) PodInterface
+	Events(namespace string) EventInterface
+	Scales(namespace string) ScaleInterface
+	HorizontalPodAutoscalers(namespace string) HorizontalPodAutoscalerInterface
+
+	//ServerVersion is the cheapest call that proves the API server can be reached
+	ServerVersion() (*version.Info, error)
+	//CanI asks the API server whether enrober's own credentials allow an action
+	CanI(check AccessCheck) (bool, error)
+}
+
+//AccessCheck is an action enrober needs to be allowed to do, an empty Namespace means every namespace
+type AccessCheck struct {
+	Namespace   string
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+}
+
+//NamespaceInterface has the namespace operations enrober uses
+type NamespaceInterface interface {
+	Create(item *api.Namespace) (*api.Namespace, error)
+	Get(name string) (*api.Namespace, error)
+	List(opts api.ListOptions) (*api.NamespaceList, error)
+	Update(item *api.Namespace) (*api.Namespace, error)
+	Delete(name string) error
+}
+
+//SecretInterface has the secret operations enrober uses
+type SecretInterface interface {
+	Create(secret *api.Secret) (*api.Secret, error)
+	Get(name string) (*api.Secret, error)
+	List(opts api.ListOptions) (*api.SecretList, error)
+	Update(secret *api.Secret) (*api.Secret, error)
+	Delete(name string) error
+}
+
+//ConfigMapInterface has the config map operations enrober uses
+type ConfigMapInterface interface {
+	Create(configMap *api.ConfigMap) (*api.ConfigMap, error)
+	Get(name string) (*api.ConfigMap, error)
+	List(opts api.ListOptions) (*api.ConfigMapList, error)
+	Update(configMap *api.ConfigMap) (*api.ConfigMap, error)
+	Delete(name string) error
+}
+
+//DeploymentInterface has the deployment operations enrober uses
+type DeploymentInterface interface {
+	Create(deployment *extensions.Deployment) (*extensions.Deployment, error)
+	Get(name string) (*extensions.Deployment, error)
+	List(opts api.ListOptions) (*extensions.DeploymentList, error)
+	Update(deployment *extensions.Deployment) (*extensions.Deployment, error)
+	Delete(name string, options *api.DeleteOptions) error
+}
+
+//ReplicaSetInterface has the replica set operations enrober uses
+type ReplicaSetInterface interface {
+	List(opts api.ListOptions) (*extensions.ReplicaSetList, error)
+	Delete(name string, options *api.DeleteOptions) error
+}
+
+//PodInterface has the pod operations enrober uses
+type PodInterface interface {
+	List(opts api.ListOptions) (*api.PodList, error)
+	Delete(name string, options *api.DeleteOptions) error
+	Watch(opts api.ListOptions) (watch.Interface, error)
+	GetLogs(name string, opts *api.PodLogOptions) *restclient.Request
+}
+
+//EventInterface has the event operations enrober uses
+type EventInterface interface {
+	List(opts api.ListOptions) (*api.EventList, error)
+	Watch(opts api.ListOptions) (watch.Interface, error)
+}
+
+//ScaleInterface has the scale subresource operations enrober uses, kind is the kind of the scaled object
+type ScaleInterface interface {
+	Get(kind string, name string) (*extensions.Scale, error)
+	Update(kind string, scale *extensions.Scale) (*extensions.Scale, error)
+}
+
+//HorizontalPodAutoscalerInterface has the autoscaler operations enrober uses
+type HorizontalPodAutoscalerInterface interface {
+	Create(hpa *autoscaling.HorizontalPodAutoscaler) (*autoscaling.HorizontalPodAutoscaler, error)
+	Get(name string) (*autoscaling.HorizontalPodAutoscaler, error)
+	List(opts api.ListOptions) (*autoscaling.HorizontalPodAutoscalerList, error)
+	Update(hpa *autoscaling.HorizontalPodAutoscaler) (*autoscaling.HorizontalPodAutoscaler, error)
+	Delete(name string, options *api.DeleteOptions) error
+}
+
+//client wraps the kubernetes client so it satisfies Interface
+type client struct {
+	client *k8sClient.Client
+}
+
+//New creates an Interface backed by a real kubernetes client, every call it makes is recorded in the metrics
+func New(config *restclient.Config) (Interface, error) {
+	wrapTransport := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrapTransport != nil {
+			rt = wrapTransport(rt)
+		}
+		return &instrumentedTransport{next: rt}
+	}
+
+	tempClient, err := k8sClient.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &client{client: tempClient}, nil
+}
+
+//NewInCluster creates an Interface from the service account the pod is running as
+func NewInCluster() (Interface, error) {
+	config, err := restclient.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return New(config)
+}
+
+func (c *client) Namespaces() NamespaceInterface {
+	return c.client.Namespaces()
+}
+
+func (c *client) Secrets(namespace string) SecretInterface {
+	return c.client.Secrets(namespace)
+}
+
+func (c *client) ConfigMaps(namespace string) ConfigMapInterface {
+	return c.client.ConfigMaps(namespace)
+}
+
+func (c *client) Deployments(namespace string) DeploymentInterface {
+	return c.client.Deployments(namespace)
+}
+
+func (c *client) ReplicaSets(namespace string) ReplicaSetInterface {
+	return c.client.ReplicaSets(namespace)
+}
+
+func (c *client) Pods(namespace string) PodInterface {
+	return c.client.Pods(namespace)
+}
+
+func (c *client) Events(namespace string) EventInterface {
+	return c.client.Events(namespace)
+}
+
+func (c *client) Scales(namespace string) ScaleInterface {
+	return c.client.Scales(namespace)
+}
+
+func (c *client) HorizontalPodAutoscalers(namespace string) HorizontalPodAutoscalerInterface {
+	return c.client.Autoscaling().HorizontalPodAutoscalers(namespace)
+}
+
+func (c *client) ServerVersion() (*version.Info, error) {
+	return c.client.ServerVersion()
+}
+
+//selfSubjectAccessReview is the authorization.k8s.io/v1beta1 resource, the client doesn't have this group
+type selfSubjectAccessReview struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Spec       struct {
+		ResourceAttributes *resourceAttributes `json:"resourceAttributes,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Allowed bool `json:"allowed"`
+	} `json:"status"`
+}
+
+type resourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb"`
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+}
+
+func (c *client) CanI(check AccessCheck) (bool, error) {
+	review := selfSubjectAccessReview{
+		Kind:       "SelfSubjectAccessReview",
+		APIVersion: "authorization.k8s.io/v1beta1",
+	}
+	review.Spec.ResourceAttributes = &resourceAttributes{
+		Namespace:   check.Namespace,
+		Verb:        check.Verb,
+		Group:       check.Group,
+		Resource:    check.Resource,
+		Subresource: check.Subresource,
+	}
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.client.RESTClient.Post().
+		AbsPath("/apis/authorization.k8s.io/v1beta1/selfsubjectaccessreviews").
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		DoRaw()
+	if err != nil {
+		return false, err
+	}
+
+	err = json.Unmarshal(result, &review)
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,80 @@
+package kubeclient
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/30x/enrober/pkg/metrics"
+)
+
+//instrumentedTransport records every kubernetes API call by verb and resource
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	code := 0
+	if err == nil {
+		code = resp.StatusCode
+	}
+	verb, resource := describeRequest(req)
+	metrics.ObserveKubernetes(verb, resource, code, time.Since(start))
+
+	return resp, err
+}
+
+//describeRequest works out the verb and resource of a kubernetes API request from its method and path.
+//Paths look like /api/v1/namespaces/{namespace}/{resource}/{name}/{subresource},
+///apis/{group}/{version}/namespaces/{namespace}/{resource}/... or /api/v1/namespaces/{name}.
+func describeRequest(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	//Drop the api prefix and version, and the group for /apis
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return strings.ToLower(req.Method), "unknown"
+	}
+
+	//Namespaced resources, namespaces themselves are left as is
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+
+	if len(parts) == 0 {
+		return strings.ToLower(req.Method), "unknown"
+	}
+
+	resource := parts[0]
+	named := len(parts) >= 2
+	if len(parts) >= 3 {
+		resource += "/" + parts[2]
+	}
+
+	switch req.Method {
+	case "GET":
+		if req.URL.Query().Get("watch") == "true" {
+			return "watch", resource
+		}
+		if named {
+			return "get", resource
+		}
+		return "list", resource
+	case "POST":
+		return "create", resource
+	case "PUT":
+		return "update", resource
+	case "PATCH":
+		return "patch", resource
+	case "DELETE":
+		return "delete", resource
+	}
+	return strings.ToLower(req.Method), resource
+}
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "enrober"
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "API requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "API request latency by route and method.",
+	}, []string{"route", "method"})
+
+	kubeRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kubernetes_requests_total",
+		Help:      "Kubernetes API calls by verb, resource and status code, error when there was no response.",
+	}, []string{"verb", "resource", "code"})
+
+	kubeRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kubernetes_request_duration_seconds",
+		Help:      "Kubernetes API call latency by verb and resource.",
+	}, []string{"verb", "resource"})
+
+	apigeeRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apigee_requests_total",
+		Help:      "Apigee management API calls by method, resource and outcome.",
+	}, []string{"method", "resource", "outcome"})
+
+	apigeeRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "apigee_request_duration_seconds",
+		Help:      "Apigee management API call latency by method and resource.",
+	}, []string{"method", "resource"})
+
+	ptsFetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pts_fetch_duration_seconds",
+		Help:      "Latency of fetching pod template specs from a ptsURL.",
+	})
+
+	ptsFetchFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pts_fetch_failures_total",
+		Help:      "Pod template spec fetches from a ptsURL that failed.",
+	})
+
+	countLock           sync.Mutex
+	countManaged        func() (environments int, deployments int, err error)
+	managedCounts       [2]float64
+	managedCountsExpire time.Time
+
+	managedEnvironments = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "environments",
+		Help:      "Environments under management.",
+	}, func() float64 { return managed(0) })
+
+	managedDeployments = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deployments",
+		Help:      "Deployments in environments under management.",
+	}, func() float64 { return managed(1) })
+)
+
+func init() {
+	for _, collector := range []prometheus.Collector{
+		requests, requestDuration,
+		kubeRequests, kubeRequestDuration,
+		apigeeRequests, apigeeRequestDuration,
+		ptsFetchDuration, ptsFetchFailures,
+		managedEnvironments, managedDeployments,
+	} {
+		prometheus.MustRegister(collector)
+	}
+}
+
+//Handler serves every registered metric
+func Handler() http.Handler {
+	return prometheus.Handler()
+}
+
+//InstrumentHandler counts and times the requests of a route.
+//route should be the path template so ids in the path don't blow up the number of series.
+func InstrumentHandler(route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder, wrapped := recordStatus(w)
+
+		handler.ServeHTTP(wrapped, r)
+
+		requests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+//ObserveKubernetes records a kubernetes API call, code is the response status or 0 when there was none
+func ObserveKubernetes(verb, resource string, code int, duration time.Duration) {
+	codeLabel := "error"
+	if code != 0 {
+		codeLabel = strconv.Itoa(code)
+	}
+	kubeRequests.WithLabelValues(verb, resource, codeLabel).Inc()
+	kubeRequestDuration.WithLabelValues(verb, resource).Observe(duration.Seconds())
+}
+
+//ObserveApigee records an Apigee management API call, outcome is success, client_error, server_error or error
+func ObserveApigee(method, resource, outcome string, duration time.Duration) {
+	apigeeRequests.WithLabelValues(method, resource, outcome).Inc()
+	apigeeRequestDuration.WithLabelValues(method, resource).Observe(duration.Seconds())
+}
+
+//ObservePTSFetch records fetching a pod template spec from a ptsURL
+func ObservePTSFetch(duration time.Duration, err error) {
+	ptsFetchDuration.Observe(duration.Seconds())
+	if err != nil {
+		ptsFetchFailures.Inc()
+	}
+}
+
+//SetManagedCounter sets how the environment and deployment gauges are counted.
+//Counts are cached for a few seconds so concurrent scrapes don't each list everything.
+func SetManagedCounter(count func() (environments int, deployments int, err error)) {
+	countLock.Lock()
+	defer countLock.Unlock()
+	countManaged = count
+	managedCountsExpire = time.Time{}
+}
+
+//managed is one of the cached counts, NaN when they can't be counted
+func managed(index int) float64 {
+	countLock.Lock()
+	defer countLock.Unlock()
+
+	if countManaged == nil {
+		return math.NaN()
+	}
+
+	if time.Now().After(managedCountsExpire) {
+		environments, deployments, err := countManaged()
+		if err != nil {
+			managedCounts = [2]float64{math.NaN(), math.NaN()}
+		} else {
+			managedCounts = [2]float64{float64(environments), float64(deployments)}
+		}
+		managedCountsExpire = time.Now().Add(5 * time.Second)
+	}
+	return managedCounts[index]
+}
+
+//statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+//recordStatus wraps w in a statusRecorder that flushes and hijacks only when w does,
+//so handlers that stream still find out when they can't
+func recordStatus(w http.ResponseWriter) (*statusRecorder, http.ResponseWriter) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	flusher, canFlush := w.(http.Flusher)
+	hijacker, canHijack := w.(http.Hijacker)
+	switch {
+	case canFlush && canHijack:
+		return recorder, struct {
+			*statusRecorder
+			http.Flusher
+			http.Hijacker
+		}{recorder, flusher, hijacker}
+	case canFlush:
+		return recorder, struct {
+			*statusRecorder
+			http.Flusher
+		}{recorder, flusher}
+	case canHijack:
+		return recorder, struct {
+			*statusRecorder
+			http.Hijacker
+		}{recorder, hijacker}
+	}
+	return recorder, recorder
+}
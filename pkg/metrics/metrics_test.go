@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//plainWriter is a ResponseWriter that can't flush or hijack
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+func TestInstrumentHandlerFlushes(t *testing.T) {
+	var canFlush bool
+	handler := InstrumentHandler("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, canFlush = w.(http.Flusher)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if !canFlush {
+		t.Errorf("Expected to flush through a writer that flushes\n")
+	}
+	if recorder.Code != http.StatusTeapot {
+		t.Errorf("Expected status %d, got %d\n", http.StatusTeapot, recorder.Code)
+	}
+
+	//Streaming handlers must be able to tell they can't flush
+	handler.ServeHTTP(plainWriter{httptest.NewRecorder()}, req)
+	if canFlush {
+		t.Errorf("Expected no flushing through a writer that can't\n")
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/autoscaling"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+//putAutoscaler creates or replaces the autoscaler of a deployment, it is named after the deployment.
+//Both ends of the replica range have to be within the bounds of the environment.
+func putAutoscaler(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	var tempJSON autoscalerPut
+	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	minReplicas := int32(1)
+	if tempJSON.MinReplicas != nil {
+		minReplicas = *tempJSON.MinReplicas
+	}
+
+	var invalid []string
+	if minReplicas < 1 {
+		invalid = append(invalid, "minReplicas must be at least 1")
+	}
+	if tempJSON.MaxReplicas < minReplicas {
+		invalid = append(invalid, "maxReplicas must be given and at least minReplicas")
+	}
+	if tempJSON.TargetCPUUtilizationPercentage != nil && *tempJSON.TargetCPUUtilizationPercentage < 1 {
+		invalid = append(invalid, "targetCPUUtilizationPercentage must be at least 1")
+	}
+	if len(invalid) > 0 {
+		errorMessage := "Invalid autoscaler\n"
+		helper.WriteError(w, errorMessage, http.StatusBadRequest, invalid...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	for _, replicas := range []int32{minReplicas, tempJSON.MaxReplicas} {
+		err = checkReplicas(namespace, replicas)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error setting autoscaler: %v\n", err)
+			helper.WriteError(w, errorMessage, replicasErrorStatus(err))
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	//The deployment has to exist, an autoscaler without a target does nothing
+	dep, err := client.Deployments(namespace).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	spec := autoscaling.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+			Kind:       "Deployment",
+			Name:       dep.Name,
+			APIVersion: "extensions/v1beta1",
+		},
+		MinReplicas:                    &minReplicas,
+		MaxReplicas:                    tempJSON.MaxReplicas,
+		TargetCPUUtilizationPercentage: tempJSON.TargetCPUUtilizationPercentage,
+	}
+
+	hpaInterface := client.HorizontalPodAutoscalers(namespace)
+
+	status := http.StatusOK
+	hpa, err := hpaInterface.Get(dep.Name)
+	if errors.IsNotFound(err) {
+		status = http.StatusCreated
+		hpa, err = hpaInterface.Create(&autoscaling.HorizontalPodAutoscaler{
+			ObjectMeta: api.ObjectMeta{
+				Name:   dep.Name,
+				Labels: dep.Spec.Selector.MatchLabels,
+			},
+			Spec: spec,
+		})
+	} else if err == nil {
+		hpa.Spec = spec
+		hpa, err = hpaInterface.Update(hpa)
+	}
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error setting autoscaler: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	writeAutoscaler(w, status, hpa)
+}
+
+//getAutoscaler returns the autoscaler of a deployment and what it is currently doing
+func getAutoscaler(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	hpa, err := client.HorizontalPodAutoscalers(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting autoscaler: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	writeAutoscaler(w, http.StatusOK, hpa)
+}
+
+//deleteAutoscaler removes the autoscaler of a deployment, which keeps the replicas it was last scaled to
+func deleteAutoscaler(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	err := client.HorizontalPodAutoscalers(pathVars["org"]+"-"+pathVars["env"]).Delete(pathVars["deployment"], &api.DeleteOptions{})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error deleting autoscaler: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	w.WriteHeader(204)
+	helper.LogInfo.Printf("Deleted Autoscaler: %s\n", pathVars["deployment"])
+}
+
+func writeAutoscaler(w http.ResponseWriter, status int, hpa *autoscaling.HorizontalPodAutoscaler) {
+	js, err := json.Marshal(newAutoscalerResponse(hpa))
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling autoscaler: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Got Autoscaler: %s\n", hpa.Name)
+}
+
+func newAutoscalerResponse(hpa *autoscaling.HorizontalPodAutoscaler) *autoscalerResponse {
+	return &autoscalerResponse{
+		MinReplicas:                     hpa.Spec.MinReplicas,
+		MaxReplicas:                     hpa.Spec.MaxReplicas,
+		TargetCPUUtilizationPercentage:  hpa.Spec.TargetCPUUtilizationPercentage,
+		CurrentReplicas:                 hpa.Status.CurrentReplicas,
+		DesiredReplicas:                 hpa.Status.DesiredReplicas,
+		CurrentCPUUtilizationPercentage: hpa.Status.CurrentCPUUtilizationPercentage,
+		LastScaleTime:                   hpa.Status.LastScaleTime,
+	}
+}
+
+//deploymentAutoscalers are the autoscalers of an environment by the deployment they scale
+func deploymentAutoscalers(namespace string) (map[string]*autoscalerResponse, error) {
+	hpaList, err := client.HorizontalPodAutoscalers(namespace).List(api.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	autoscalers := map[string]*autoscalerResponse{}
+	for i := range hpaList.Items {
+		hpa := &hpaList.Items[i]
+		if hpa.Spec.ScaleTargetRef.Kind == "Deployment" {
+			autoscalers[hpa.Spec.ScaleTargetRef.Name] = newAutoscalerResponse(hpa)
+		}
+	}
+	return autoscalers, nil
+}
@@ -0,0 +1,572 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/validation"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+const (
+	//configVolumePrefix names the volumes of mounted configs, volumes named config-{name} are managed by enrober
+	configVolumePrefix = "config-"
+	//configChecksumAnnotation is set on the pod template so changing a mounted config rolls the pods
+	configChecksumAnnotation = "configChecksum"
+)
+
+//configKeyRegexp is what a file name in a config can be, like a config map key
+var configKeyRegexp = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+//validateConfigName returns what's wrong with a config name, it has to fit in the name of its volume
+func validateConfigName(name string) []string {
+	invalid := validation.IsDNS1123Label(name)
+	if len(configVolumePrefix+name) > validation.DNS1123LabelMaxLength {
+		invalid = append(invalid, fmt.Sprintf("must be no more than %d characters", validation.DNS1123LabelMaxLength-len(configVolumePrefix)))
+	}
+	return invalid
+}
+
+//validateConfigKey returns what's wrong with a file name in a config
+func validateConfigKey(key string) []string {
+	var invalid []string
+	if !configKeyRegexp.MatchString(key) {
+		invalid = append(invalid, fmt.Sprintf("file name %q may only have letters, digits, '-', '_' and '.'", key))
+	}
+	if key == "." || strings.HasPrefix(key, "..") {
+		invalid = append(invalid, fmt.Sprintf("file name %q can't be . or start with ..", key))
+	}
+	return invalid
+}
+
+//configChecksum is a hash of a config's files
+func configChecksum(configMap *api.ConfigMap) string {
+	data := make(map[string][]byte, len(configMap.Data))
+	for key, value := range configMap.Data {
+		data[key] = []byte(value)
+	}
+	return dataChecksum(data)
+}
+
+//mountedConfigs is the name of every config map a pod spec mounts, whether enrober mounted it or not
+func mountedConfigs(spec *api.PodSpec) []string {
+	var names []string
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil {
+			names = append(names, volume.ConfigMap.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+//mountsConfig is true when a pod spec mounts the named config map
+func mountsConfig(spec *api.PodSpec, name string) bool {
+	for _, mounted := range mountedConfigs(spec) {
+		if mounted == name {
+			return true
+		}
+	}
+	return false
+}
+
+//setConfigChecksum sets the checksum annotation of a pod template from the config maps it mounts,
+//so the pods roll when any of them changes. Config maps that don't exist yet are left out.
+func setConfigChecksum(namespace string, template *api.PodTemplateSpec) error {
+	checksums := map[string][]byte{}
+	for _, name := range mountedConfigs(&template.Spec) {
+		configMap, err := client.ConfigMaps(namespace).Get(name)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		checksums[name] = []byte(configChecksum(configMap))
+	}
+
+	if len(checksums) == 0 {
+		delete(template.Annotations, configChecksumAnnotation)
+		return nil
+	}
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[configChecksumAnnotation] = dataChecksum(checksums)
+	return nil
+}
+
+//configMounts lists the configs enrober mounted in a pod spec, one per config and mount path
+func configMounts(spec *api.PodSpec) []configMount {
+	var mounts []configMount
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap == nil || !strings.HasPrefix(volume.Name, configVolumePrefix) {
+			continue
+		}
+		byPath := map[string]int{}
+		for _, container := range spec.Containers {
+			for _, volumeMount := range container.VolumeMounts {
+				if volumeMount.Name != volume.Name {
+					continue
+				}
+				index, ok := byPath[volumeMount.MountPath]
+				if !ok {
+					index = len(mounts)
+					byPath[volumeMount.MountPath] = index
+					mounts = append(mounts, configMount{
+						Name:      volume.ConfigMap.Name,
+						MountPath: volumeMount.MountPath,
+					})
+				}
+				mounts[index].Containers = append(mounts[index].Containers, container.Name)
+			}
+		}
+	}
+	return mounts
+}
+
+//keepConfigMounts is the configs mounted in a previous pod spec that can be mounted in the updated one,
+//a container that's gone loses its mounts
+func keepConfigMounts(previous, updated *api.PodSpec) []configMount {
+	var kept []configMount
+	for _, mount := range configMounts(previous) {
+		containers := mount.Containers[:0]
+		for _, containerName := range mount.Containers {
+			if findContainer(updated, containerName) != nil {
+				containers = append(containers, containerName)
+			}
+		}
+		if len(containers) > 0 {
+			mount.Containers = containers
+			kept = append(kept, mount)
+		}
+	}
+	return kept
+}
+
+//mountConfigs mounts configs read only in containers of a pod spec, the first container when a mount doesn't name any.
+//Mounts already in the spec are changed in place so an unchanged list doesn't change the spec.
+//When exclusive is true the configs enrober mounted that aren't listed are removed.
+//It returns what's wrong with the mounts, and leaves the spec alone then.
+func mountConfigs(namespace string, spec *api.PodSpec, mounts []configMount, exclusive bool) ([]string, error) {
+	if len(spec.Containers) == 0 {
+		return []string{"pod template spec has no containers"}, nil
+	}
+
+	//volumeMounts of the configs, by container then volume then path
+	wanted := map[string]map[string]map[string]bool{}
+	var invalid []string
+	for _, mount := range mounts {
+		for _, problem := range validateConfigName(mount.Name) {
+			invalid = append(invalid, fmt.Sprintf("config name %s %s", mount.Name, problem))
+		}
+		if !path.IsAbs(mount.MountPath) {
+			invalid = append(invalid, fmt.Sprintf("mountPath of config %s must be an absolute path", mount.Name))
+		}
+
+		volumeName := configVolumePrefix + mount.Name
+		containers := mount.Containers
+		if len(containers) == 0 {
+			containers = []string{spec.Containers[0].Name}
+		}
+		for _, containerName := range containers {
+			container := findContainer(spec, containerName)
+			if container == nil {
+				invalid = append(invalid, fmt.Sprintf("config %s is mounted in container %s that doesn't exist", mount.Name, containerName))
+				continue
+			}
+			for _, volumeMount := range container.VolumeMounts {
+				if volumeMount.MountPath == mount.MountPath && volumeMount.Name != volumeName {
+					invalid = append(invalid, fmt.Sprintf("container %s already mounts %s at %s", containerName, volumeMount.Name, mount.MountPath))
+				}
+			}
+			if wanted[containerName] == nil {
+				wanted[containerName] = map[string]map[string]bool{}
+			}
+			if wanted[containerName][volumeName] == nil {
+				wanted[containerName][volumeName] = map[string]bool{}
+			}
+			wanted[containerName][volumeName][mount.MountPath] = true
+		}
+	}
+	if len(invalid) > 0 {
+		return invalid, nil
+	}
+
+	//Mounting a config that doesn't exist would leave the pods pending
+	for _, mount := range mounts {
+		_, err := client.ConfigMaps(namespace).Get(mount.Name)
+		if errors.IsNotFound(err) {
+			invalid = append(invalid, fmt.Sprintf("config %s doesn't exist", mount.Name))
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(invalid) > 0 {
+		return invalid, nil
+	}
+
+	//The managed volumes before any are added, those that aren't wanted are dropped when exclusive
+	managed := map[string]bool{}
+	if exclusive {
+		volumes := spec.Volumes[:0]
+		for _, volume := range spec.Volumes {
+			if volume.ConfigMap != nil && strings.HasPrefix(volume.Name, configVolumePrefix) {
+				managed[volume.Name] = true
+				if !mountsVolume(mounts, volume.Name) {
+					continue
+				}
+			}
+			volumes = append(volumes, volume)
+		}
+		spec.Volumes = volumes
+	}
+
+	for _, mount := range mounts {
+		setConfigVolume(spec, configVolumePrefix+mount.Name, mount.Name)
+	}
+
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		volumeMounts := container.VolumeMounts[:0]
+		mounted := map[string]map[string]bool{}
+		for _, volumeMount := range container.VolumeMounts {
+			if wanted[container.Name][volumeMount.Name][volumeMount.MountPath] {
+				volumeMount.ReadOnly = true
+				if mounted[volumeMount.Name] == nil {
+					mounted[volumeMount.Name] = map[string]bool{}
+				}
+				mounted[volumeMount.Name][volumeMount.MountPath] = true
+			} else if managed[volumeMount.Name] {
+				continue
+			}
+			volumeMounts = append(volumeMounts, volumeMount)
+		}
+
+		//New mounts go after the existing ones, in the order they were listed
+		for _, mount := range mounts {
+			volumeName := configVolumePrefix + mount.Name
+			if !wanted[container.Name][volumeName][mount.MountPath] || mounted[volumeName][mount.MountPath] {
+				continue
+			}
+			if mounted[volumeName] == nil {
+				mounted[volumeName] = map[string]bool{}
+			}
+			mounted[volumeName][mount.MountPath] = true
+			volumeMounts = append(volumeMounts, api.VolumeMount{
+				Name:      volumeName,
+				MountPath: mount.MountPath,
+				ReadOnly:  true,
+			})
+		}
+		container.VolumeMounts = volumeMounts
+	}
+	return nil, nil
+}
+
+//setConfigVolume adds the volume of a config to a pod spec, or points the volume at it if it's there
+func setConfigVolume(spec *api.PodSpec, volumeName, configName string) {
+	source := api.VolumeSource{
+		ConfigMap: &api.ConfigMapVolumeSource{
+			LocalObjectReference: api.LocalObjectReference{Name: configName},
+		},
+	}
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == volumeName {
+			if spec.Volumes[i].ConfigMap == nil || spec.Volumes[i].ConfigMap.Name != configName {
+				spec.Volumes[i].VolumeSource = source
+			}
+			return
+		}
+	}
+	spec.Volumes = append(spec.Volumes, api.Volume{Name: volumeName, VolumeSource: source})
+}
+
+//mountsVolume is true when one of the mounts is of the config the volume is named after
+func mountsVolume(mounts []configMount, volumeName string) bool {
+	for _, mount := range mounts {
+		if configVolumePrefix+mount.Name == volumeName {
+			return true
+		}
+	}
+	return false
+}
+
+//findContainer returns the named container of a pod spec, nil when there's none
+func findContainer(spec *api.PodSpec, name string) *api.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return &spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+//configDeployments lists the deployments in a namespace that mount each config map, by config map name
+func configDeployments(namespace string) (map[string][]extensions.Deployment, error) {
+	depList, err := client.Deployments(namespace).List(api.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	mounting := map[string][]extensions.Deployment{}
+	for _, dep := range depList.Items {
+		for _, name := range mountedConfigs(&dep.Spec.Template.Spec) {
+			mounting[name] = append(mounting[name], dep)
+		}
+	}
+	return mounting, nil
+}
+
+//newConfigResponse describes a config and the names of the deployments that mount it
+func newConfigResponse(configMap *api.ConfigMap, deployments []extensions.Deployment) configResponse {
+	response := configResponse{
+		Name:        configMap.Name,
+		Data:        configMap.Data,
+		Deployments: []string{},
+	}
+	for _, dep := range deployments {
+		response.Deployments = append(response.Deployments, dep.Name)
+	}
+	sort.Strings(response.Deployments)
+	return response
+}
+
+//getConfigs lists the configs of an environment
+func getConfigs(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	configMapList, err := client.ConfigMaps(namespace).List(api.ListOptions{})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing configs: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	mounting, err := configDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	list := configList{Items: []configResponse{}}
+	for i := range configMapList.Items {
+		configMap := &configMapList.Items[i]
+		list.Items = append(list.Items, newConfigResponse(configMap, mounting[configMap.Name]))
+	}
+	sort.Sort(configsByName(list.Items))
+
+	js, err := json.Marshal(list)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling configs: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+}
+
+//getConfig returns a config of an environment
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	configMap, err := client.ConfigMaps(namespace).Get(pathVars["name"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving config: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	mounting, err := configDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	writeConfig(w, http.StatusOK, newConfigResponse(configMap, mounting[configMap.Name]))
+}
+
+//putConfig creates or replaces a config of an environment.
+//The deployments that mount it get a new checksum annotation so their pods roll with the new files.
+func putConfig(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+	name := pathVars["name"]
+
+	var tempJSON configPut
+	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	var invalid []string
+	for _, problem := range validateConfigName(name) {
+		invalid = append(invalid, "name "+problem)
+	}
+	if tempJSON.Data == nil {
+		invalid = append(invalid, "data must be given")
+	}
+	for key := range tempJSON.Data {
+		invalid = append(invalid, validateConfigKey(key)...)
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		errorMessage := "Invalid config\n"
+		helper.WriteError(w, errorMessage, http.StatusBadRequest, invalid...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	configMapInterface := client.ConfigMaps(namespace)
+
+	status := http.StatusOK
+	configMap, err := configMapInterface.Get(name)
+	if errors.IsNotFound(err) {
+		status = http.StatusCreated
+		configMap, err = configMapInterface.Create(&api.ConfigMap{
+			ObjectMeta: api.ObjectMeta{
+				Name: name,
+			},
+			Data: tempJSON.Data,
+		})
+	} else if err == nil {
+		configMap.Data = tempJSON.Data
+		configMap, err = configMapInterface.Update(configMap)
+	}
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error setting config: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	mounting, err := configDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//Only the annotation changes, so the policies aren't applied to what is only a restart.
+	//The config is already stored, a deployment that can't be rolled is logged and left for its next update.
+	for i := range mounting[name] {
+		dep := &mounting[name][i]
+		previous := dep.Spec.Template.Annotations[configChecksumAnnotation]
+		err = setConfigChecksum(namespace, &dep.Spec.Template)
+		if err == nil && dep.Spec.Template.Annotations[configChecksumAnnotation] != previous {
+			_, err = client.Deployments(namespace).Update(dep)
+		}
+		if err != nil {
+			helper.LogError.Printf("Error rolling Deployment %s for config %s: %v\n", dep.Name, name, err)
+			continue
+		}
+		helper.LogInfo.Printf("Rolled Deployment %s for config %s\n", dep.Name, name)
+	}
+
+	writeConfig(w, status, newConfigResponse(configMap, mounting[name]))
+	helper.LogInfo.Printf("Set Config: %s\n", configMap.Name)
+}
+
+//deleteConfig removes a config from an environment, as long as no deployment mounts it
+func deleteConfig(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+	name := pathVars["name"]
+
+	mounting, err := configDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if len(mounting[name]) > 0 {
+		var names []string
+		for _, dep := range mounting[name] {
+			names = append(names, dep.Name)
+		}
+		sort.Strings(names)
+		errorMessage := fmt.Sprintf("Config %s is mounted by deployments\n", name)
+		helper.WriteError(w, errorMessage, http.StatusConflict, names...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	err = client.ConfigMaps(namespace).Delete(name)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error deleting config: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	w.WriteHeader(204)
+	helper.LogInfo.Printf("Deleted Config: %s\n", name)
+}
+
+func writeConfig(w http.ResponseWriter, status int, response configResponse) {
+	js, err := json.Marshal(response)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling config: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
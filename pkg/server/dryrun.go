@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+//diffDeployment lists the fields an update changed in a deployment by their path in its JSON,
+//before is the genericJSON of the deployment taken before it was changed
+func diffDeployment(before interface{}, after *extensions.Deployment) ([]fieldChange, error) {
+	afterJSON, err := genericJSON(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []fieldChange{}
+	diffJSON("", before, afterJSON, &changes)
+	return changes, nil
+}
+
+//genericJSON is an object as the maps, slices and values it marshals to
+func genericJSON(object interface{}) (interface{}, error) {
+	js, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(js, &generic)
+	return generic, err
+}
+
+//diffJSON compares objects field by field, with their fields in order, and arrays element by element, anything else is compared whole
+func diffJSON(path string, before, after interface{}, changes *[]fieldChange) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		keys := make([]string, 0, len(beforeMap)+len(afterMap))
+		for key := range beforeMap {
+			keys = append(keys, key)
+		}
+		for key := range afterMap {
+			if _, ok := beforeMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			beforeValue, inBefore := beforeMap[key]
+			afterValue, inAfter := afterMap[key]
+			switch {
+			case !inBefore:
+				*changes = append(*changes, fieldChange{Path: fieldPath, Op: "add", New: afterValue})
+			case !inAfter:
+				*changes = append(*changes, fieldChange{Path: fieldPath, Op: "remove", Old: beforeValue})
+			default:
+				diffJSON(fieldPath, beforeValue, afterValue, changes)
+			}
+		}
+		return
+	}
+
+	beforeSlice, beforeIsSlice := before.([]interface{})
+	afterSlice, afterIsSlice := after.([]interface{})
+	if beforeIsSlice && afterIsSlice {
+		for i := 0; i < len(beforeSlice) || i < len(afterSlice); i++ {
+			elementPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(beforeSlice):
+				*changes = append(*changes, fieldChange{Path: elementPath, Op: "add", New: afterSlice[i]})
+			case i >= len(afterSlice):
+				*changes = append(*changes, fieldChange{Path: elementPath, Op: "remove", Old: beforeSlice[i]})
+			default:
+				diffJSON(elementPath, beforeSlice[i], afterSlice[i], changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*changes = append(*changes, fieldChange{Path: path, Op: "replace", Old: before, New: after})
+	}
+}
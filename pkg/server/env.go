@@ -0,0 +1,450 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/validation"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+const (
+	//envSecretSuffix names the secret holding the secret env var values of a deployment
+	envSecretSuffix = "-env"
+	//envSecretChecksumAnnotation is set on the pod template so changing a secret value rolls the pods,
+	//env vars from a secret are only read when a container starts
+	envSecretChecksumAnnotation = "envSecretChecksum"
+)
+
+//envSecretName is the name of the secret holding the secret env vars of a deployment
+func envSecretName(deployment string) string {
+	return deployment + envSecretSuffix
+}
+
+//envSecretKey is the key of an env var in the secret, containers can each have their own value
+func envSecretKey(container, name string) string {
+	return container + "." + name
+}
+
+//dataChecksum is a hash of the keys and values of a secret, in key order
+func dataChecksum(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%d:%s%d:", len(key), key, len(data[key]))
+		hash.Write(data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//usesEnvSecret is true when an env var takes its value from the env secret of a deployment
+func usesEnvSecret(envVar api.EnvVar, secretName string) bool {
+	return envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil && envVar.ValueFrom.SecretKeyRef.Name == secretName
+}
+
+//envContainer finds the container named in the container query, the first container when there isn't one
+func envContainer(dep *extensions.Deployment, r *http.Request) (*api.Container, error) {
+	containers := dep.Spec.Template.Spec.Containers
+	name := r.URL.Query().Get("container")
+	if name == "" {
+		if len(containers) == 0 {
+			return nil, fmt.Errorf("Deployment %s has no containers", dep.Name)
+		}
+		return &containers[0], nil
+	}
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Deployment %s has no container %s", dep.Name, name)
+}
+
+//keepSecretEnvVars carries the secret env vars of containers over to a replacement pod template,
+//where it has a container of the same name that doesn't set the var itself.
+//Their values can't be read back, so a new pod template spec couldn't have them.
+func keepSecretEnvVars(previous, updated *api.PodTemplateSpec, secretName string) {
+	kept := false
+	for _, previousContainer := range previous.Spec.Containers {
+		for i := range updated.Spec.Containers {
+			container := &updated.Spec.Containers[i]
+			if container.Name != previousContainer.Name {
+				continue
+			}
+			for _, envVar := range previousContainer.Env {
+				if !usesEnvSecret(envVar, secretName) || findEnvVar(container.Env, envVar.Name) != -1 {
+					continue
+				}
+				container.Env = append(container.Env, envVar)
+				kept = true
+			}
+		}
+	}
+
+	if kept {
+		if updated.Annotations == nil {
+			updated.Annotations = make(map[string]string)
+		}
+		updated.Annotations[envSecretChecksumAnnotation] = previous.Annotations[envSecretChecksumAnnotation]
+	}
+}
+
+//findEnvVar is the index of the env var with the given name, -1 when there isn't one
+func findEnvVar(envVars []api.EnvVar, name string) int {
+	for i, envVar := range envVars {
+		if envVar.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+//newEnvVarResponse describes an env var, the values of secret ones are never included
+func newEnvVarResponse(container string, envVar api.EnvVar, secretName string) envVarResponse {
+	response := envVarResponse{
+		Name:      envVar.Name,
+		Container: container,
+		ValueFrom: envVar.ValueFrom,
+		Secret:    usesEnvSecret(envVar, secretName),
+	}
+	if envVar.ValueFrom == nil {
+		response.Value = &envVar.Value
+	}
+	return response
+}
+
+//getEnvVar returns an env var of a container in a deployment
+func getEnvVar(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	dep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	container, err := envContainer(dep, r)
+	if err != nil {
+		errorMessage := fmt.Sprintf("%v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusNotFound)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	index := findEnvVar(container.Env, pathVars["name"])
+	if index == -1 {
+		errorMessage := fmt.Sprintf("Container %s has no env var %s\n", container.Name, pathVars["name"])
+		helper.WriteError(w, errorMessage, http.StatusNotFound)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	writeEnvVar(w, http.StatusOK, newEnvVarResponse(container.Name, container.Env[index], envSecretName(dep.Name)))
+}
+
+//putEnvVar sets an env var of a container in a deployment, rolling its pods.
+//Secret values are kept in the deployment's env secret and referenced from the container.
+func putEnvVar(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+	name := pathVars["name"]
+
+	var tempJSON envVarPut
+	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if tempJSON.Value == nil {
+		errorMessage := "No value given\n"
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if !validation.IsCIdentifier(name) {
+		errorMessage := fmt.Sprintf("Invalid env var name %s, it must be a C identifier\n", name)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	dep, err := client.Deployments(namespace).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	container, err := envContainer(dep, r)
+	if err != nil {
+		errorMessage := fmt.Sprintf("%v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusNotFound)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	secretName := envSecretName(dep.Name)
+	key := envSecretKey(container.Name, name)
+
+	envVar := api.EnvVar{
+		Name:  name,
+		Value: *tempJSON.Value,
+	}
+
+	//Secret values change the secret first so the deployment never references a missing key,
+	//previousValue is put back if the deployment can't be updated
+	var previousValue []byte
+	if tempJSON.Secret {
+		envVar = api.EnvVar{
+			Name: name,
+			ValueFrom: &api.EnvVarSource{
+				SecretKeyRef: &api.SecretKeySelector{
+					LocalObjectReference: api.LocalObjectReference{Name: secretName},
+					Key:                  key,
+				},
+			},
+		}
+
+		var checksum string
+		checksum, previousValue, err = setEnvSecretValue(namespace, secretName, key, []byte(*tempJSON.Value))
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error storing secret env var: %v\n", err)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+		if dep.Spec.Template.Annotations == nil {
+			dep.Spec.Template.Annotations = make(map[string]string)
+		}
+		dep.Spec.Template.Annotations[envSecretChecksumAnnotation] = checksum
+	}
+
+	status := http.StatusOK
+	index := findEnvVar(container.Env, name)
+	wasSecret := false
+	if index == -1 {
+		status = http.StatusCreated
+		container.Env = append(container.Env, envVar)
+	} else {
+		wasSecret = usesEnvSecret(container.Env[index], secretName)
+		container.Env[index] = envVar
+	}
+
+	err = applyPolicies(namespace, &dep.Spec.Template.Spec)
+	if err != nil {
+		if tempJSON.Secret {
+			restoreEnvSecretValue(namespace, secretName, key, previousValue)
+		}
+		errorMessage := fmt.Sprintf("Error updating deployment: %v\n", err)
+		status, details := policyErrorStatus(err)
+		helper.WriteError(w, errorMessage, status, details...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	dep, err = client.Deployments(namespace).Update(dep)
+	if err != nil {
+		if tempJSON.Secret {
+			restoreEnvSecretValue(namespace, secretName, key, previousValue)
+		}
+		errorMessage := fmt.Sprintf("Error updating deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//A value that's no longer secret is removed from the secret once nothing references it
+	if wasSecret && !tempJSON.Secret {
+		removeEnvSecretValue(namespace, secretName, key)
+	}
+
+	writeEnvVar(w, status, newEnvVarResponse(container.Name, envVar, secretName))
+	helper.LogInfo.Printf("Set env var %s of %s in Deployment: %s\n", name, container.Name, dep.GetName())
+}
+
+//deleteEnvVar removes an env var from a container in a deployment, and its value from the env secret
+func deleteEnvVar(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+	name := pathVars["name"]
+
+	dep, err := client.Deployments(namespace).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	container, err := envContainer(dep, r)
+	if err != nil {
+		errorMessage := fmt.Sprintf("%v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusNotFound)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	index := findEnvVar(container.Env, name)
+	if index == -1 {
+		errorMessage := fmt.Sprintf("Container %s has no env var %s\n", container.Name, name)
+		helper.WriteError(w, errorMessage, http.StatusNotFound)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	secretName := envSecretName(dep.Name)
+	wasSecret := usesEnvSecret(container.Env[index], secretName)
+	container.Env = append(container.Env[:index], container.Env[index+1:]...)
+
+	err = applyPolicies(namespace, &dep.Spec.Template.Spec)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error updating deployment: %v\n", err)
+		status, details := policyErrorStatus(err)
+		helper.WriteError(w, errorMessage, status, details...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	_, err = client.Deployments(namespace).Update(dep)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error updating deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if wasSecret {
+		removeEnvSecretValue(namespace, secretName, envSecretKey(container.Name, name))
+	}
+
+	w.WriteHeader(204)
+	helper.LogInfo.Printf("Deleted env var %s of %s in Deployment: %s\n", name, container.Name, dep.GetName())
+}
+
+//setEnvSecretValue stores a value in a deployment's env secret, creating the secret when it's the first.
+//It returns the checksum of the secret's data and the value it replaced, nil when the key wasn't set.
+func setEnvSecretValue(namespace, secretName, key string, value []byte) (string, []byte, error) {
+	secretInterface := client.Secrets(namespace)
+
+	secret, err := secretInterface.Get(secretName)
+	if errors.IsNotFound(err) {
+		secret, err = secretInterface.Create(&api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name: secretName,
+			},
+			Type: api.SecretTypeOpaque,
+			Data: map[string][]byte{key: value},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		return dataChecksum(secret.Data), nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	previous, ok := secret.Data[key]
+	if ok && previous == nil {
+		previous = []byte{}
+	}
+	secret.Data[key] = value
+	secret, err = secretInterface.Update(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	return dataChecksum(secret.Data), previous, nil
+}
+
+//restoreEnvSecretValue puts back the value setEnvSecretValue replaced, or removes the key when there wasn't one.
+//The request has already failed so failures are only logged.
+func restoreEnvSecretValue(namespace, secretName, key string, previous []byte) {
+	if previous == nil {
+		removeEnvSecretValue(namespace, secretName, key)
+		return
+	}
+	_, _, err := setEnvSecretValue(namespace, secretName, key, previous)
+	if err != nil {
+		helper.LogError.Printf("Error restoring %s in secret %s: %v\n", key, secretName, err)
+	}
+}
+
+//removeEnvSecretValue removes a value from a deployment's env secret, and the secret when it was the last.
+//The env var is already gone so failures are only logged.
+func removeEnvSecretValue(namespace, secretName, key string) {
+	secretInterface := client.Secrets(namespace)
+
+	secret, err := secretInterface.Get(secretName)
+	if err != nil {
+		helper.LogError.Printf("Error retrieving secret %s to remove %s: %v\n", secretName, key, err)
+		return
+	}
+
+	delete(secret.Data, key)
+	if len(secret.Data) == 0 {
+		err = secretInterface.Delete(secretName)
+	} else {
+		_, err = secretInterface.Update(secret)
+	}
+	if err != nil {
+		helper.LogError.Printf("Error removing %s from secret %s: %v\n", key, secretName, err)
+	}
+}
+
+func writeEnvVar(w http.ResponseWriter, status int, response envVarResponse) {
+	js, err := json.Marshal(response)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling env var: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/api/errors"
+
+	"github.com/30x/enrober/pkg/apigee"
+	"github.com/30x/enrober/pkg/helper"
+)
+
+//kubeErrorStatus is the status to return for an error from the kubernetes client
+func kubeErrorStatus(err error) int {
+	switch {
+	case errors.IsNotFound(err):
+		return http.StatusNotFound
+	case errors.IsAlreadyExists(err), errors.IsConflict(err):
+		return http.StatusConflict
+	case errors.IsInvalid(err), errors.IsBadRequest(err):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+//apigeeErrorStatus is the status to return for an error from the Apigee management API.
+//Apigee rejecting the caller's token is passed on, anything else is an upstream failure.
+func apigeeErrorStatus(err error) int {
+	if apigeeErr, ok := err.(*apigee.Error); ok {
+		if apigeeErr.StatusCode == http.StatusUnauthorized || apigeeErr.StatusCode == http.StatusForbidden {
+			return apigeeErr.StatusCode
+		}
+	}
+	return http.StatusBadGateway
+}
+
+//ptsErrorStatus is the status to return for an error from helper.GetPTSFromURL
+func ptsErrorStatus(err error) int {
+	if _, ok := err.(*helper.InvalidPTSURLError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+//withRequestID makes sure every request carries an X-Request-Id, generating one if the caller didn't,
+//and echoes it on the response so error bodies can report it
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(helper.RequestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID, _ = helper.GenerateRandomString(12)
+			r.Header.Set(helper.RequestIDHeader, requestID)
+		}
+		w.Header().Set(helper.RequestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+//notFound is the router's reply for paths that don't match any route
+func notFound(w http.ResponseWriter, r *http.Request) {
+	helper.WriteError(w, "No route for "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+//getEnvironmentEvents returns the kubernetes events of every object in an environment
+func getEnvironmentEvents(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	//Make sure the environment exists, an empty event list would hide a typo
+	_, err := client.Namespaces().Get(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting environment: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	serveEvents(w, r, namespace, func(event *api.Event) bool {
+		return true
+	})
+}
+
+//getDeploymentEvents returns the kubernetes events of a deployment and of its replica sets and pods,
+//found with the same component label selector as its logs
+func getDeploymentEvents(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	dep, err := client.Deployments(namespace).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	filter, err := newDeploymentEventFilter(namespace, dep)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error finding the objects of the deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	serveEvents(w, r, namespace, filter.matches)
+}
+
+//serveEvents replies with the events of a namespace that pass filter.
+//since leaves out older events, and watch=true streams new ones as server-sent events after the existing ones.
+func serveEvents(w http.ResponseWriter, r *http.Request, namespace string, filter func(*api.Event) bool) {
+	queries := r.URL.Query()
+
+	var since time.Time
+	if sinceString := queries.Get("since"); sinceString != "" {
+		var err error
+		since, err = parseSince(sinceString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid since value: %s, must be a duration such as 10m or an RFC3339 time\n", sinceString)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	watchString := queries.Get("watch")
+	var watchEvents bool
+	if watchString != "" {
+		var err error
+		watchEvents, err = strconv.ParseBool(watchString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid watch value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	eventList, err := client.Events(namespace).List(api.ListOptions{})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving events: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	records := []eventRecord{}
+	for i := range eventList.Items {
+		event := &eventList.Items[i]
+		if event.LastTimestamp.Time.Before(since) || !filter(event) {
+			continue
+		}
+		records = append(records, newEventRecord(event))
+	}
+	sort.Sort(eventsByTime(records))
+
+	if watchEvents {
+		streamEvents(w, r, namespace, eventList.ResourceVersion, records, filter)
+		return
+	}
+
+	js, err := json.Marshal(eventRecordList{Items: records})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling events: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Got %d Events in %s\n", len(records), namespace)
+}
+
+//streamEvents sends the existing events followed by new ones as they are recorded, one server-sent event each,
+//until the client goes away or the server shuts down
+func streamEvents(w http.ResponseWriter, r *http.Request, namespace, resourceVersion string, records []eventRecord, filter func(*api.Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorMessage := "Streaming unsupported\n"
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	watcher, err := client.Events(namespace).Watch(api.ListOptions{
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error watching events: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	defer watcher.Stop()
+
+	ctx, cancel := streams.track(r.Context())
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+
+	for _, record := range records {
+		if writeServerSentEvent(w, record) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case watchEvent, ok := <-watcher.ResultChan():
+			if !ok {
+				helper.LogWarn.Printf("Event watch closed while streaming events\n")
+				return
+			}
+			event, isEvent := watchEvent.Object.(*api.Event)
+			if !isEvent || watchEvent.Type == watch.Deleted || !filter(event) {
+				continue
+			}
+			if writeServerSentEvent(w, newEventRecord(event)) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
+			helper.LogInfo.Printf("Finished streaming Events in %s\n", namespace)
+			return
+		}
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, record eventRecord) error {
+	js, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", record.Type, js)
+	return err
+}
+
+//parseSince reads a since value, either a duration back from now or an RFC3339 time
+func parseSince(since string) (time.Time, error) {
+	duration, err := time.ParseDuration(since)
+	if err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	return time.Parse(time.RFC3339, since)
+}
+
+func newEventRecord(event *api.Event) eventRecord {
+	return eventRecord{
+		Type:    event.Type,
+		Reason:  event.Reason,
+		Message: event.Message,
+		InvolvedObject: eventObject{
+			Kind: event.InvolvedObject.Kind,
+			Name: event.InvolvedObject.Name,
+		},
+		Source:         event.Source.Component,
+		Count:          event.Count,
+		FirstTimestamp: event.FirstTimestamp,
+		LastTimestamp:  event.LastTimestamp,
+	}
+}
+
+//deploymentEventFilter matches events about a deployment, its replica sets and their pods
+type deploymentEventFilter struct {
+	namespace string
+	dep       *extensions.Deployment
+	selector  labels.Selector
+
+	replicaSets map[string]bool
+	pods        map[string]bool
+	refreshed   time.Time
+}
+
+//eventFilterRefreshInterval stops events about other deployments' objects with a similar name listing everything each time
+const eventFilterRefreshInterval = 5 * time.Second
+
+func newDeploymentEventFilter(namespace string, dep *extensions.Deployment) (*deploymentEventFilter, error) {
+	selector, err := componentSelector(dep)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &deploymentEventFilter{
+		namespace: namespace,
+		dep:       dep,
+		selector:  selector,
+	}
+	err = filter.refresh()
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+//refresh lists the replica sets and pods of the deployment again
+func (f *deploymentEventFilter) refresh() error {
+	if time.Since(f.refreshed) < eventFilterRefreshInterval {
+		return nil
+	}
+	f.refreshed = time.Now()
+
+	rsList, err := client.ReplicaSets(f.namespace).List(api.ListOptions{
+		LabelSelector: f.selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	podList, err := client.Pods(f.namespace).List(api.ListOptions{
+		LabelSelector: f.selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.replicaSets = map[string]bool{}
+	for _, rs := range rsList.Items {
+		f.replicaSets[rs.Name] = true
+	}
+	f.pods = map[string]bool{}
+	for _, pod := range podList.Items {
+		f.pods[pod.Name] = true
+	}
+	return nil
+}
+
+//matches is true for events about the deployment's objects. Pods that are already gone are matched by
+//the name of their replica set, objects created since the last refresh make it refresh.
+func (f *deploymentEventFilter) matches(event *api.Event) bool {
+	object := event.InvolvedObject
+	switch object.Kind {
+	case "Deployment":
+		return object.Name == f.dep.Name
+	case "ReplicaSet":
+		if !f.replicaSets[object.Name] && strings.HasPrefix(object.Name, f.dep.Name+"-") {
+			f.refresh()
+		}
+		return f.replicaSets[object.Name]
+	case "Pod":
+		if f.pods[object.Name] || f.ownedPod(object.Name) {
+			return true
+		}
+		if strings.HasPrefix(object.Name, f.dep.Name+"-") {
+			f.refresh()
+		}
+		return f.pods[object.Name] || f.ownedPod(object.Name)
+	}
+	return false
+}
+
+//ownedPod is true when a pod is named after one of the replica sets
+func (f *deploymentEventFilter) ownedPod(name string) bool {
+	for rsName := range f.replicaSets {
+		if strings.HasPrefix(name, rsName+"-") {
+			return true
+		}
+	}
+	return false
+}
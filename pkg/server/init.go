@@ -1,53 +1,40 @@
 package server
 
 import (
-	"os"
-
 	"k8s.io/kubernetes/pkg/client/restclient"
 
-	k8sClient "k8s.io/kubernetes/pkg/client/unversioned"
+	"github.com/30x/enrober/pkg/config"
+	"github.com/30x/enrober/pkg/kubeclient"
 )
 
 //Init runs once
-func Init(clientConfig restclient.Config) error {
-	var tempClient *k8sClient.Client
+func Init(cfg *config.Config) error {
+	var tempClient kubeclient.Interface
+	var err error
 
 	//In Cluster Config
-	if clientConfig.Host == "" {
-		tempConfig, err := restclient.InClusterConfig()
+	if cfg.InCluster() {
+		tempClient, err = kubeclient.NewInCluster()
 		if err != nil {
 			return err
 		}
-		tempClient, err = k8sClient.New(tempConfig)
-
-		client = *tempClient
 
 		//Local Config
 	} else {
-		tempClient, err := k8sClient.New(&clientConfig)
+		tempClient, err = kubeclient.New(&restclient.Config{
+			Host: cfg.KubeHost,
+		})
 		if err != nil {
 			return err
 		}
-		client = *tempClient
 	}
 
-	//Several features should be disabled for local testing
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
-
-		if os.Getenv("ISOLATE_NAMESPACE") == "true" {
-			isolateNamespace = true
-		}
-
-		//Set privileged container flag
-		if os.Getenv("ALLOW_PRIV_CONTAINERS") == "true" {
-			allowPrivilegedContainers = true
-		}
+	return InitWithClient(tempClient)
+}
 
-		//Set apigeeKVM flag
-		if os.Getenv("APIGEE_KVM") == "true" {
-			apigeeKVM = true
-		}
-	}
+//InitWithClient runs once with an already built kubernetes client, tests use it to pass in a fake
+func InitWithClient(kubeClient kubeclient.Interface) error {
+	client = kubeClient
 
 	return nil
 }
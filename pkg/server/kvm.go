@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+
+	"github.com/30x/enrober/pkg/apigee"
+	"github.com/30x/enrober/pkg/helper"
+)
+
+//getKVMStatus compares the public key in Apigee's routing KVM with the one in the environment's routing secret
+func getKVMStatus(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	getSecret, err := client.Secrets(namespace).Get("routing")
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get existing routing secret on %s namespace\n", namespace)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	status, err := compareKVM(r.Header.Get("Authorization"), pathVars["org"], pathVars["env"], getSecret)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting Apigee KVM: %v\n", err)
+		helper.WriteError(w, errorMessage, apigeeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	js, err := json.Marshal(status)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshaling KVM status: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Got KVM status for %s, in sync: %t\n", namespace, status.InSync)
+}
+
+//syncKVM pushes the public key in the environment's routing secret to Apigee's routing KVM, creating the KVM if needed
+func syncKVM(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	getSecret, err := client.Secrets(namespace).Get("routing")
+	if err != nil {
+		errorMessage := fmt.Sprintf("Failed to get existing routing secret on %s namespace\n", namespace)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	err = pushKVM(r.Header.Get("Authorization"), pathVars["org"], pathVars["env"], getSecret)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error syncing Apigee KVM: %v\n", err)
+		helper.WriteError(w, errorMessage, apigeeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//Read it back rather than assuming Apigee took the value
+	status, err := compareKVM(r.Header.Get("Authorization"), pathVars["org"], pathVars["env"], getSecret)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting Apigee KVM: %v\n", err)
+		helper.WriteError(w, errorMessage, apigeeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	js, err := json.Marshal(status)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshaling KVM status: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Synced KVM for %s\n", namespace)
+}
+
+//compareKVM reports whether the routing KVM holds the public key of a routing secret.
+//A missing KVM is drift, not an error.
+func compareKVM(authz, org, env string, secret *api.Secret) (*kvmStatus, error) {
+	status := &kvmStatus{
+		Name: org + "-" + env,
+	}
+
+	kvm, err := apigeeClient.GetKVM(authz, org, env, apigeeKVMName)
+	if apigee.IsNotFound(err) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status.KVMExists = true
+
+	expected := routingKVM(secret.Data["public-api-key"]).Entry[0]
+	for _, entry := range kvm.Entry {
+		if entry.Name == expected.Name {
+			status.InSync = entry.Value == expected.Value
+		}
+	}
+	return status, nil
+}
+
+//pushKVM writes the public key of a routing secret to the routing KVM
+func pushKVM(authz, org, env string, secret *api.Secret) error {
+	kvm := routingKVM(secret.Data["public-api-key"])
+
+	err := apigeeClient.CreateKVM(authz, org, env, kvm)
+	if apigee.IsConflict(err) {
+		err = apigeeClient.UpdateKVMEntries(authz, org, env, kvm)
+	}
+	return err
+}
+
+//reconcileKVMs compares the routing KVM of every shipyard environment with its routing secret,
+//pushing the secret's key to Apigee when fix is set.
+//Environments are found by the Organziation and Environment labels createEnvironment puts on the namespace.
+func reconcileKVMs(authz string, fix bool) {
+	selector, err := labels.Parse("Runtime=shipyard")
+	if err != nil {
+		helper.LogError.Printf("Error parsing label selector: %v\n", err)
+		return
+	}
+
+	nsList, err := client.Namespaces().List(api.ListOptions{LabelSelector: selector})
+	if err != nil {
+		helper.LogError.Printf("KVM reconciler failed to list namespaces: %v\n", err)
+		return
+	}
+
+	for _, ns := range nsList.Items {
+		org := ns.Labels["Organziation"]
+		env := ns.Labels["Environment"]
+		if org == "" || env == "" {
+			continue
+		}
+
+		getSecret, err := client.Secrets(ns.Name).Get("routing")
+		if err != nil {
+			helper.LogError.Printf("KVM reconciler failed to get routing secret on %s namespace: %v\n", ns.Name, err)
+			continue
+		}
+
+		status, err := compareKVM(authz, org, env, getSecret)
+		if err != nil {
+			helper.LogError.Printf("KVM reconciler failed to get Apigee KVM for %s: %v\n", ns.Name, err)
+			continue
+		}
+		if status.InSync {
+			continue
+		}
+
+		if !fix {
+			helper.LogError.Printf("Apigee KVM for %s doesn't match its routing secret, exists: %t\n", ns.Name, status.KVMExists)
+			continue
+		}
+
+		err = pushKVM(authz, org, env, getSecret)
+		if err != nil {
+			helper.LogError.Printf("KVM reconciler failed to sync Apigee KVM for %s: %v\n", ns.Name, err)
+			continue
+		}
+		helper.LogInfo.Printf("KVM reconciler synced Apigee KVM for %s\n", ns.Name)
+	}
+}
+
+//runKVMReconciler calls reconcileKVMs every interval until ctx is done
+func runKVMReconciler(ctx context.Context, interval time.Duration, authz string, fix bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reconcileKVMs(authz, fix)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+//listQuery is the filtering, sorting and paging asked for in a list request's query string
+type listQuery struct {
+	options  api.ListOptions
+	sort     string
+	limit    int
+	after    string
+	viewMode string
+}
+
+//listContinue is the decoded form of a continue token, the sort key of the last item on the previous page
+type listContinue struct {
+	Sort  string `json:"sort"`
+	After string `json:"after"`
+}
+
+//parseListQuery reads labelSelector, fieldSelector, sort, limit, continue and view.
+//The kubernetes API can't page yet, so paging is done over the full list using a key of the last item
+//so a page doesn't skip or repeat items when something earlier in the list is added or removed.
+func parseListQuery(query url.Values) (*listQuery, error) {
+	list := &listQuery{
+		options: api.ListOptions{
+			LabelSelector: labels.Everything(),
+			FieldSelector: fields.Everything(),
+		},
+		sort:     "name",
+		viewMode: "full",
+	}
+
+	if selector := query.Get("labelSelector"); selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid labelSelector: %v", err)
+		}
+		list.options.LabelSelector = parsed
+	}
+
+	if selector := query.Get("fieldSelector"); selector != "" {
+		parsed, err := fields.ParseSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid fieldSelector: %v", err)
+		}
+		list.options.FieldSelector = parsed
+	}
+
+	switch sortBy := query.Get("sort"); sortBy {
+	case "":
+	case "name", "created":
+		list.sort = sortBy
+	default:
+		return nil, fmt.Errorf("Invalid sort %q, must be name or created", sortBy)
+	}
+
+	switch viewMode := query.Get("view"); viewMode {
+	case "":
+	case "full", "summary":
+		list.viewMode = viewMode
+	default:
+		return nil, fmt.Errorf("Invalid view %q, must be full or summary", viewMode)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("Invalid limit %q, must be a positive number", limit)
+		}
+		list.limit = parsed
+	}
+
+	if token := query.Get("continue"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid continue token")
+		}
+		var cont listContinue
+		err = json.Unmarshal(decoded, &cont)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid continue token")
+		}
+		if cont.Sort != list.sort {
+			return nil, fmt.Errorf("The continue token is for sort=%s, not sort=%s", cont.Sort, list.sort)
+		}
+		list.after = cont.After
+	}
+
+	return list, nil
+}
+
+//sortKey orders items by name, or by creation time with the name breaking ties
+func (l *listQuery) sortKey(meta api.ObjectMeta) string {
+	if l.sort == "created" {
+		return meta.CreationTimestamp.UTC().Format("2006-01-02T15:04:05Z") + "/" + meta.Name
+	}
+	return meta.Name
+}
+
+//pageDeployments sorts the deployments and cuts out the page asked for.
+//The continue token is empty on the last page.
+func (l *listQuery) pageDeployments(items []extensions.Deployment) ([]extensions.Deployment, string) {
+	sort.Sort(&deploymentsByKey{items, l})
+
+	start := 0
+	if l.after != "" {
+		start = sort.Search(len(items), func(i int) bool {
+			return l.sortKey(items[i].ObjectMeta) > l.after
+		})
+	}
+	items = items[start:]
+
+	if l.limit == 0 || len(items) <= l.limit {
+		return items, ""
+	}
+
+	items = items[:l.limit]
+	cont, _ := json.Marshal(listContinue{
+		Sort:  l.sort,
+		After: l.sortKey(items[len(items)-1].ObjectMeta),
+	})
+	return items, base64.RawURLEncoding.EncodeToString(cont)
+}
+
+//deploymentsByKey sorts deployments by the sort key of a listQuery
+type deploymentsByKey struct {
+	items []extensions.Deployment
+	list  *listQuery
+}
+
+func (d *deploymentsByKey) Len() int      { return len(d.items) }
+func (d *deploymentsByKey) Swap(i, j int) { d.items[i], d.items[j] = d.items[j], d.items[i] }
+func (d *deploymentsByKey) Less(i, j int) bool {
+	return d.list.sortKey(d.items[i].ObjectMeta) < d.list.sortKey(d.items[j].ObjectMeta)
+}
+
+//summarizeDeployment is the summary view of a deployment, the fields enrober lets callers set
+func summarizeDeployment(environment string, dep extensions.Deployment) deploymentResponse {
+	template := dep.Spec.Template
+	return deploymentResponse{
+		DeploymentName:  dep.Name,
+		PublicHosts:     template.Annotations["publicHosts"],
+		PublicPaths:     template.Annotations["publicPaths"],
+		PrivateHosts:    template.Annotations["privateHosts"],
+		PrivatePaths:    template.Annotations["privatePaths"],
+		Replicas:        dep.Spec.Replicas,
+		Environment:     environment,
+		PodTemplateSpec: &template,
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+//policyError is a pod spec that breaks the policies of an environment
+type policyError struct {
+	namespace  string
+	violations []string
+}
+
+func (e *policyError) Error() string {
+	return fmt.Sprintf("Pod template spec breaks the policies of %s: %s", e.namespace, strings.Join(e.violations, "; "))
+}
+
+//policyErrorStatus is the status and details to return for an error from applyPolicies
+func policyErrorStatus(err error) (int, []string) {
+	if policyErr, ok := err.(*policyError); ok {
+		return http.StatusBadRequest, policyErr.violations
+	}
+	return kubeErrorStatus(err), nil
+}
+
+//applyPolicies applies the resource and security policies of an environment to a pod spec.
+//Policies that override change the spec, a policyError lists every violation of those that reject.
+func applyPolicies(namespace string, spec *api.PodSpec) error {
+	ns, err := client.Namespaces().Get(namespace)
+	if err != nil {
+		return err
+	}
+
+	resourceViolations, err := applyResourcePolicy(ns, spec)
+	if err != nil {
+		return err
+	}
+
+	securityViolations, err := applySecurityPolicy(ns, spec)
+	if err != nil {
+		return err
+	}
+
+	violations := append(resourceViolations, securityViolations...)
+	if len(violations) > 0 {
+		return &policyError{namespace: namespace, violations: violations}
+	}
+	return nil
+}
+
+//podContainers points at every container of a pod spec, init containers included
+func podContainers(spec *api.PodSpec) []*api.Container {
+	containers := make([]*api.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+	return containers
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/30x/enrober/pkg/helper"
+	"github.com/30x/enrober/pkg/kubeclient"
+)
+
+//apigeePingTimeout bounds how long a readiness check waits on the Apigee management API
+const apigeePingTimeout = 5 * time.Second
+
+var (
+	//readinessCache is how long passing access and Apigee checks are reused
+	readinessCache time.Duration
+
+	accessCheck = &cachedCheck{check: checkAccess}
+	apigeeCheck = &cachedCheck{check: checkApigee}
+)
+
+//cachedCheck reuses the last passing result of a dependency check for readinessCache, so probes
+//don't cost dozens of SelfSubjectAccessReviews and an Apigee call each.
+//Failures are checked again on the next probe so the pod is ready again as soon as they're fixed.
+type cachedCheck struct {
+	check func() dependencyStatus
+
+	lock    sync.Mutex
+	status  dependencyStatus
+	checked time.Time
+}
+
+//get returns the cached status while it's passing and fresh, or runs the check.
+//Concurrent probes wait for a single check.
+func (c *cachedCheck) get() dependencyStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.status.Ready && time.Since(c.checked) < readinessCache {
+		return c.status
+	}
+	c.status = c.check()
+	c.checked = time.Now()
+	return c.status
+}
+
+//reset drops the cached status
+func (c *cachedCheck) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.status = dependencyStatus{}
+}
+
+//SetReadinessCache changes how long passing readiness checks are reused and drops those cached, tests use it to check again
+func SetReadinessCache(d time.Duration) {
+	readinessCache = d
+	accessCheck.reset()
+	apigeeCheck.reset()
+}
+
+//requiredAccess is every action enrober takes against the kubernetes API, across all namespaces.
+//The server tests fail when enrober uses an action that isn't listed.
+var requiredAccess = []kubeclient.AccessCheck{
+	{Verb: "create", Resource: "namespaces"},
+	{Verb: "get", Resource: "namespaces"},
+	{Verb: "list", Resource: "namespaces"},
+	{Verb: "update", Resource: "namespaces"},
+	{Verb: "delete", Resource: "namespaces"},
+	{Verb: "create", Resource: "secrets"},
+	{Verb: "get", Resource: "secrets"},
+	{Verb: "list", Resource: "secrets"},
+	{Verb: "update", Resource: "secrets"},
+	{Verb: "delete", Resource: "secrets"},
+	{Verb: "create", Resource: "configmaps"},
+	{Verb: "get", Resource: "configmaps"},
+	{Verb: "list", Resource: "configmaps"},
+	{Verb: "update", Resource: "configmaps"},
+	{Verb: "delete", Resource: "configmaps"},
+	{Verb: "create", Group: "extensions", Resource: "deployments"},
+	{Verb: "get", Group: "extensions", Resource: "deployments"},
+	{Verb: "list", Group: "extensions", Resource: "deployments"},
+	{Verb: "update", Group: "extensions", Resource: "deployments"},
+	{Verb: "delete", Group: "extensions", Resource: "deployments"},
+	{Verb: "get", Group: "extensions", Resource: "deployments", Subresource: "scale"},
+	{Verb: "update", Group: "extensions", Resource: "deployments", Subresource: "scale"},
+	{Verb: "list", Group: "extensions", Resource: "replicasets"},
+	{Verb: "delete", Group: "extensions", Resource: "replicasets"},
+	{Verb: "list", Resource: "pods"},
+	{Verb: "watch", Resource: "pods"},
+	{Verb: "delete", Resource: "pods"},
+	{Verb: "get", Resource: "pods", Subresource: "log"},
+	{Verb: "create", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	{Verb: "get", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	{Verb: "list", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	{Verb: "update", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	{Verb: "delete", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	{Verb: "list", Resource: "events"},
+	{Verb: "watch", Resource: "events"},
+}
+
+//getReadiness checks every dependency enrober needs to serve requests.
+//It replies 503 when any of them isn't ready so the pod is taken out of the service.
+func getReadiness(w http.ResponseWriter, r *http.Request) {
+	status := readiness{
+		Ready: true,
+	}
+
+	kubeStatus := checkKubernetes()
+	status.Dependencies = append(status.Dependencies, kubeStatus)
+
+	//Without a reachable API server every access check would fail with the same error
+	if kubeStatus.Ready {
+		status.Dependencies = append(status.Dependencies, accessCheck.get())
+	} else {
+		status.Dependencies = append(status.Dependencies, dependencyStatus{
+			Name:    "kubernetesAccess",
+			Message: "Not checked, kubernetes is unreachable",
+		})
+	}
+
+	if apigeeKVM {
+		status.Dependencies = append(status.Dependencies, apigeeCheck.get())
+	}
+
+	for _, dependency := range status.Dependencies {
+		if !dependency.Ready {
+			status.Ready = false
+		}
+	}
+
+	js, err := json.Marshal(status)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling readiness: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		helper.LogError.Printf("Not ready: %s\n", js)
+	}
+	w.Write(js)
+}
+
+//checkKubernetes checks the API server can be reached
+func checkKubernetes() dependencyStatus {
+	info, err := client.ServerVersion()
+	if err != nil {
+		return dependencyStatus{
+			Name:    "kubernetes",
+			Message: fmt.Sprintf("Error reaching the API server: %v", err),
+		}
+	}
+	return dependencyStatus{
+		Name:    "kubernetes",
+		Ready:   true,
+		Message: info.GitVersion,
+	}
+}
+
+//checkAccess checks the service account is still allowed everything in requiredAccess.
+//The checks are independent so they run at the same time.
+func checkAccess() dependencyStatus {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var denied []string
+	var failed []string
+
+	for _, check := range requiredAccess {
+		wg.Add(1)
+		go func(check kubeclient.AccessCheck) {
+			defer wg.Done()
+
+			allowed, err := client.CanI(check)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", describeAccess(check), err))
+			} else if !allowed {
+				denied = append(denied, describeAccess(check))
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	status := dependencyStatus{
+		Name: "kubernetesAccess",
+	}
+	switch {
+	case len(failed) > 0:
+		status.Message = "Error checking access: " + strings.Join(failed, ", ")
+	case len(denied) > 0:
+		status.Message = "Not allowed to " + strings.Join(denied, ", ")
+	default:
+		status.Ready = true
+	}
+	return status
+}
+
+//describeAccess reads like "get pods/log"
+func describeAccess(check kubeclient.AccessCheck) string {
+	resource := check.Resource
+	if check.Subresource != "" {
+		resource += "/" + check.Subresource
+	}
+	return check.Verb + " " + resource
+}
+
+//checkApigee checks the management API can be reached, only done when enrober manages KVMs
+func checkApigee() dependencyStatus {
+	err := apigeeClient.Ping(apigeePingTimeout)
+	if err != nil {
+		return dependencyStatus{
+			Name:    "apigee",
+			Message: fmt.Sprintf("Error reaching %s: %v", apigeeClient.BaseURL, err),
+		}
+	}
+	return dependencyStatus{
+		Name:    "apigee",
+		Ready:   true,
+		Message: apigeeClient.BaseURL,
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+const (
+	//Namespace annotations an operator sets to bound the compute resources of every container in an environment
+	defaultCPURequestAnnotation    = "defaultCPURequest"
+	defaultMemoryRequestAnnotation = "defaultMemoryRequest"
+	defaultCPULimitAnnotation      = "defaultCPULimit"
+	defaultMemoryLimitAnnotation   = "defaultMemoryLimit"
+	maxContainerCPUAnnotation      = "maxContainerCPU"
+	maxContainerMemoryAnnotation   = "maxContainerMemory"
+)
+
+//resourcePolicy is what an environment's namespace annotations say about container resources.
+//Resources without an annotation are missing from the lists.
+type resourcePolicy struct {
+	defaultRequests api.ResourceList
+	defaultLimits   api.ResourceList
+	maxLimits       api.ResourceList
+}
+
+//getResourcePolicy reads the resource policy set on an environment's namespace
+func getResourcePolicy(ns *api.Namespace) (*resourcePolicy, error) {
+	policy := &resourcePolicy{
+		defaultRequests: api.ResourceList{},
+		defaultLimits:   api.ResourceList{},
+		maxLimits:       api.ResourceList{},
+	}
+
+	annotations := []struct {
+		name     string
+		list     api.ResourceList
+		resource api.ResourceName
+	}{
+		{defaultCPURequestAnnotation, policy.defaultRequests, api.ResourceCPU},
+		{defaultMemoryRequestAnnotation, policy.defaultRequests, api.ResourceMemory},
+		{defaultCPULimitAnnotation, policy.defaultLimits, api.ResourceCPU},
+		{defaultMemoryLimitAnnotation, policy.defaultLimits, api.ResourceMemory},
+		{maxContainerCPUAnnotation, policy.maxLimits, api.ResourceCPU},
+		{maxContainerMemoryAnnotation, policy.maxLimits, api.ResourceMemory},
+	}
+	for _, annotation := range annotations {
+		value, ok := ns.Annotations[annotation.name]
+		if !ok {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("Invalid %s annotation on %s: %q", annotation.name, ns.Name, value)
+		}
+		annotation.list[annotation.resource] = quantity
+	}
+
+	//A default limit over the maximum would make every container without limits fail
+	for name, limit := range policy.defaultLimits {
+		if max, ok := policy.maxLimits[name]; ok && limit.Cmp(max) > 0 {
+			return nil, fmt.Errorf("Default %s limit %s on %s is over the maximum of %s", name, limit.String(), ns.Name, max.String())
+		}
+	}
+	return policy, nil
+}
+
+//applyResourcePolicy fills in the default requests and limits of an environment for the containers
+//of a pod spec that don't set them, then returns the limits that are over the maximum and the requests over their limit.
+//Containers without a limit and without a default limit get the maximum, so nothing runs unbounded
+//in an environment that has one.
+func applyResourcePolicy(ns *api.Namespace, spec *api.PodSpec) ([]string, error) {
+	policy, err := getResourcePolicy(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []string
+	for _, container := range podContainers(spec) {
+		resources := &container.Resources
+
+		for _, name := range []api.ResourceName{api.ResourceCPU, api.ResourceMemory} {
+			limit, hasLimit := resources.Limits[name]
+			if !hasLimit {
+				limit, hasLimit = policy.defaultLimits[name]
+				if !hasLimit {
+					limit, hasLimit = policy.maxLimits[name]
+				}
+				if hasLimit {
+					if resources.Limits == nil {
+						resources.Limits = api.ResourceList{}
+					}
+					resources.Limits[name] = *limit.Copy()
+				}
+			}
+
+			if request, hasRequest := resources.Requests[name]; hasRequest {
+				//Kubernetes would reject the pod without saying why, the limit may be the maximum filled in above
+				if hasLimit && request.Cmp(limit) > 0 {
+					violations = append(violations, fmt.Sprintf("container %s requests %s of %s, over its limit of %s", container.Name, name, request.String(), limit.String()))
+				}
+			} else if request, ok := policy.defaultRequests[name]; ok {
+				//Requests can't be over the limit, kubernetes would reject the pod
+				if hasLimit && request.Cmp(limit) > 0 {
+					request = limit
+				}
+				if resources.Requests == nil {
+					resources.Requests = api.ResourceList{}
+				}
+				resources.Requests[name] = *request.Copy()
+			}
+
+			if max, ok := policy.maxLimits[name]; ok && limit.Cmp(max) > 0 {
+				violations = append(violations, fmt.Sprintf("container %s has a %s limit of %s, the maximum is %s", container.Name, name, limit.String(), max.String()))
+			}
+		}
+	}
+
+	return violations, nil
+}
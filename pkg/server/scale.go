@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+const (
+	//Namespace annotations an operator sets to bound the replicas of every deployment in an environment
+	minReplicasAnnotation = "minReplicas"
+	maxReplicasAnnotation = "maxReplicas"
+)
+
+//replicaBoundsError is a replica count outside the bounds of an environment
+type replicaBoundsError struct {
+	message string
+}
+
+func (e *replicaBoundsError) Error() string {
+	return e.message
+}
+
+//replicasErrorStatus is the status to return for an error from checkReplicas
+func replicasErrorStatus(err error) int {
+	if _, ok := err.(*replicaBoundsError); ok {
+		return http.StatusBadRequest
+	}
+	return kubeErrorStatus(err)
+}
+
+//replicaBounds reads the replica bounds set on an environment's namespace, a max of 0 is no limit
+func replicaBounds(ns *api.Namespace) (int32, int32, error) {
+	var bounds [2]int32
+	for i, annotation := range []string{minReplicasAnnotation, maxReplicasAnnotation} {
+		value, ok := ns.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("Invalid %s annotation on %s: %q", annotation, ns.Name, value)
+		}
+		bounds[i] = int32(parsed)
+	}
+	return bounds[0], bounds[1], nil
+}
+
+//checkReplicas returns a replicaBoundsError when replicas is outside the bounds of the environment
+func checkReplicas(namespace string, replicas int32) error {
+	ns, err := client.Namespaces().Get(namespace)
+	if err != nil {
+		return err
+	}
+
+	min, max, err := replicaBounds(ns)
+	if err != nil {
+		return err
+	}
+
+	if replicas < 0 {
+		return &replicaBoundsError{fmt.Sprintf("replicas can't be negative, got %d", replicas)}
+	}
+	if replicas < min {
+		return &replicaBoundsError{fmt.Sprintf("replicas must be at least %d in %s, got %d", min, namespace, replicas)}
+	}
+	if max != 0 && replicas > max {
+		return &replicaBoundsError{fmt.Sprintf("replicas can be at most %d in %s, got %d", max, namespace, replicas)}
+	}
+	return nil
+}
+
+//scaleDeployment sets the replicas of a deployment through its scale subresource.
+//Only Spec.Replicas changes, so it can't race with an update of the template.
+func scaleDeployment(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	var tempJSON deploymentScale
+	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if tempJSON.Replicas == nil {
+		errorMessage := "No replicas given\n"
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	err = checkReplicas(namespace, *tempJSON.Replicas)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error scaling deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, replicasErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//Fails fast when the deployment doesn't exist
+	scale, err := client.Scales(namespace).Get("Deployment", pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting deployment scale: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//Without a resource version the update doesn't conflict with template changes made in the meantime
+	scale = &extensions.Scale{
+		ObjectMeta: api.ObjectMeta{
+			Name:      scale.Name,
+			Namespace: namespace,
+		},
+		Spec: extensions.ScaleSpec{
+			Replicas: *tempJSON.Replicas,
+		},
+	}
+
+	updatedScale, err := client.Scales(namespace).Update("Deployment", scale)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error scaling deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	js, err := json.Marshal(updatedScale)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling scale: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Scaled Deployment %s to %d\n", updatedScale.Name, updatedScale.Spec.Replicas)
+}
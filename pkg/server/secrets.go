@@ -0,0 +1,357 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/validation"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+const (
+	//userSecretLabel marks the secrets created through the secrets API, it only ever sees those
+	userSecretLabel = "userSecret"
+)
+
+//validateUserSecretName returns what's wrong with the name of a user secret.
+//The routing secret, the image pull secret and the env secrets of deployments are enrober's, so their names can't be used.
+func validateUserSecretName(name string) []string {
+	invalid := validation.IsDNS1123Subdomain(name)
+	if name == "routing" {
+		invalid = append(invalid, "routing is the environment's routing secret")
+	}
+	if imagePullSecret != "" && name == imagePullSecret {
+		invalid = append(invalid, fmt.Sprintf("%s is the environment's image pull secret", name))
+	}
+	if strings.HasSuffix(name, envSecretSuffix) {
+		invalid = append(invalid, fmt.Sprintf("names ending in %s are for the env secrets of deployments", envSecretSuffix))
+	}
+	return invalid
+}
+
+//isUserSecret is true for Opaque secrets created through the secrets API
+func isUserSecret(secret *api.Secret) bool {
+	return secret.Labels[userSecretLabel] == "true" && secret.Type == api.SecretTypeOpaque
+}
+
+//getUserSecret gets a user secret, other secrets are NotFound so nothing about them is given away
+func getUserSecret(namespace, name string) (*api.Secret, error) {
+	secret, err := client.Secrets(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !isUserSecret(secret) {
+		return nil, errors.NewNotFound(api.Resource("secrets"), name)
+	}
+	return secret, nil
+}
+
+//secretReferences is the name of every secret a pod spec uses in env vars or volumes
+func secretReferences(spec *api.PodSpec) []string {
+	var names []string
+	for _, container := range podContainers(spec) {
+		for _, envVar := range container.Env {
+			if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+				names = append(names, envVar.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			names = append(names, volume.Secret.SecretName)
+		}
+	}
+	return names
+}
+
+//secretDeployments lists the names of the deployments in a namespace that use each secret, by secret name
+func secretDeployments(namespace string) (map[string][]string, error) {
+	depList, err := client.Deployments(namespace).List(api.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	using := map[string][]string{}
+	for _, dep := range depList.Items {
+		seen := map[string]bool{}
+		for _, name := range secretReferences(&dep.Spec.Template.Spec) {
+			if !seen[name] {
+				seen[name] = true
+				using[name] = append(using[name], dep.Name)
+			}
+		}
+	}
+	for name := range using {
+		sort.Strings(using[name])
+	}
+	return using, nil
+}
+
+//newSecretResponse describes a user secret by its keys, its values are never returned
+func newSecretResponse(secret *api.Secret, deployments []string) secretResponse {
+	response := secretResponse{
+		Name:            secret.Name,
+		Keys:            []string{},
+		ResourceVersion: secret.ResourceVersion,
+		Deployments:     deployments,
+	}
+	for key := range secret.Data {
+		response.Keys = append(response.Keys, key)
+	}
+	sort.Strings(response.Keys)
+	if response.Deployments == nil {
+		response.Deployments = []string{}
+	}
+	return response
+}
+
+//getSecrets lists the user secrets of an environment
+func getSecrets(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	labelSelector, err := labels.Parse(userSecretLabel + "=true")
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error parsing label selector: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	secrets, err := client.Secrets(namespace).List(api.ListOptions{
+		LabelSelector: labelSelector,
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing secrets: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	using, err := secretDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	list := secretList{Items: []secretResponse{}}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if isUserSecret(secret) {
+			list.Items = append(list.Items, newSecretResponse(secret, using[secret.Name]))
+		}
+	}
+	sort.Sort(secretsByName(list.Items))
+
+	js, err := json.Marshal(list)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling secrets: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+}
+
+//getSecret returns the keys of a user secret
+func getSecret(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	secret, err := getUserSecret(namespace, pathVars["name"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving secret: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	using, err := secretDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	writeSecret(w, http.StatusOK, newSecretResponse(secret, using[secret.Name]))
+}
+
+//putSecret creates or replaces a user secret. Secrets enrober or anyone else created outside the API can't be replaced.
+func putSecret(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+	name := pathVars["name"]
+
+	var tempJSON secretPut
+	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	var invalid []string
+	for _, problem := range validateUserSecretName(name) {
+		invalid = append(invalid, "name "+problem)
+	}
+	if tempJSON.Data == nil {
+		invalid = append(invalid, "data must be given")
+	}
+	for key := range tempJSON.Data {
+		invalid = append(invalid, validateConfigKey(key)...)
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		errorMessage := "Invalid secret\n"
+		helper.WriteError(w, errorMessage, http.StatusBadRequest, invalid...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	data := make(map[string][]byte, len(tempJSON.Data))
+	for key, value := range tempJSON.Data {
+		data[key] = []byte(value)
+	}
+
+	secretInterface := client.Secrets(namespace)
+
+	status := http.StatusOK
+	secret, err := secretInterface.Get(name)
+	switch {
+	case errors.IsNotFound(err):
+		status = http.StatusCreated
+		secret, err = secretInterface.Create(&api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					userSecretLabel: "true",
+				},
+			},
+			Data: data,
+			Type: api.SecretTypeOpaque,
+		})
+	case err != nil:
+	case !isUserSecret(secret):
+		errorMessage := fmt.Sprintf("Secret %s wasn't created through the secrets API\n", name)
+		helper.WriteError(w, errorMessage, http.StatusConflict)
+		helper.LogError.Printf(errorMessage)
+		return
+	default:
+		secret.Data = data
+		secret, err = secretInterface.Update(secret)
+	}
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error setting secret: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	using, err := secretDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	writeSecret(w, status, newSecretResponse(secret, using[name]))
+	helper.LogInfo.Printf("Set Secret: %s\n", secret.Name)
+}
+
+//deleteSecret removes a user secret from an environment, as long as no deployment uses it
+func deleteSecret(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+	name := pathVars["name"]
+
+	_, err := getUserSecret(namespace, name)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving secret: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	using, err := secretDeployments(namespace)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error listing deployments: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if len(using[name]) > 0 {
+		errorMessage := fmt.Sprintf("Secret %s is used by deployments\n", name)
+		helper.WriteError(w, errorMessage, http.StatusConflict, using[name]...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	err = client.Secrets(namespace).Delete(name)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error deleting secret: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	w.WriteHeader(204)
+	helper.LogInfo.Printf("Deleted Secret: %s\n", name)
+}
+
+func writeSecret(w http.ResponseWriter, status int, response secretResponse) {
+	js, err := json.Marshal(response)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling secret: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
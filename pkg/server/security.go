@@ -0,0 +1,280 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+//securityMode is what a security rule does with a pod spec that breaks it
+type securityMode string
+
+const (
+	//securityAllow doesn't check the rule
+	securityAllow securityMode = "allow"
+	//securityReject fails the request, listing the violations
+	securityReject securityMode = "reject"
+	//securityOverride changes the spec so it follows the rule
+	securityOverride securityMode = "override"
+)
+
+const (
+	//Namespace annotation an operator sets to limit where the images of an environment come from.
+	//It's a space delimited list of registries, or registries with a repository path.
+	allowedRegistriesAnnotation = "allowedRegistries"
+)
+
+//securityRule is one check of the pod security policy. Operators set its mode per environment
+//with an annotation on the namespace, the mode is defaultMode when there is none.
+type securityRule struct {
+	annotation  string
+	defaultMode func() securityMode
+	//hostAccess rules are governed by ALLOW_PRIV_CONTAINERS, when privileged containers
+	//aren't allowed an environment can't allow them either
+	hostAccess bool
+	//canOverride is false for rules there's no safe way to fix
+	canOverride bool
+	//check returns the violations of a spec. When fix is true it first changes the spec to follow the rule,
+	//returning only what it couldn't fix.
+	check func(spec *api.PodSpec, fix bool) []string
+}
+
+//hostAccessDefault is the mode of host access rules without an annotation
+func hostAccessDefault(restricted securityMode) func() securityMode {
+	return func() securityMode {
+		if allowPrivilegedContainers {
+			return securityAllow
+		}
+		return restricted
+	}
+}
+
+func allowByDefault() securityMode {
+	return securityAllow
+}
+
+var securityRules = []securityRule{
+	{
+		annotation:  "privilegedPolicy",
+		defaultMode: hostAccessDefault(securityOverride),
+		hostAccess:  true,
+		canOverride: true,
+		check:       checkPrivileged,
+	},
+	{
+		annotation:  "hostNetworkPolicy",
+		defaultMode: hostAccessDefault(securityReject),
+		hostAccess:  true,
+		canOverride: true,
+		check:       checkHostNetwork,
+	},
+	{
+		annotation:  "hostPIDPolicy",
+		defaultMode: hostAccessDefault(securityReject),
+		hostAccess:  true,
+		canOverride: true,
+		check:       checkHostPID,
+	},
+	{
+		annotation:  "hostPathPolicy",
+		defaultMode: hostAccessDefault(securityReject),
+		hostAccess:  true,
+		check:       checkHostPath,
+	},
+	{
+		annotation:  "capabilitiesPolicy",
+		defaultMode: allowByDefault,
+		canOverride: true,
+		check:       checkCapabilities,
+	},
+	{
+		annotation:  "runAsNonRootPolicy",
+		defaultMode: allowByDefault,
+		canOverride: true,
+		check:       checkRunAsNonRoot,
+	},
+}
+
+//mode reads the mode of a rule from an environment's namespace
+func (rule *securityRule) mode(ns *api.Namespace) (securityMode, error) {
+	value, ok := ns.Annotations[rule.annotation]
+	if !ok {
+		return rule.defaultMode(), nil
+	}
+
+	mode := securityMode(value)
+	switch {
+	case mode == securityAllow && rule.hostAccess && !allowPrivilegedContainers:
+		return rule.defaultMode(), nil
+	case mode == securityAllow, mode == securityReject, mode == securityOverride && rule.canOverride:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Invalid %s annotation on %s: %q", rule.annotation, ns.Name, value)
+}
+
+//applySecurityPolicy runs every security rule of an environment on a pod spec.
+//Rules set to override change the spec, the violations of the others are returned.
+func applySecurityPolicy(ns *api.Namespace, spec *api.PodSpec) ([]string, error) {
+	var violations []string
+	for i := range securityRules {
+		rule := &securityRules[i]
+		mode, err := rule.mode(ns)
+		if err != nil {
+			return nil, err
+		}
+		if mode == securityAllow {
+			continue
+		}
+		violations = append(violations, rule.check(spec, mode == securityOverride)...)
+	}
+
+	if registries, ok := ns.Annotations[allowedRegistriesAnnotation]; ok {
+		violations = append(violations, checkRegistries(spec, strings.Fields(registries))...)
+	}
+	return violations, nil
+}
+
+func checkPrivileged(spec *api.PodSpec, fix bool) []string {
+	var violations []string
+	for _, container := range podContainers(spec) {
+		context := container.SecurityContext
+		if context == nil || context.Privileged == nil || !*context.Privileged {
+			continue
+		}
+		if fix {
+			privileged := false
+			context.Privileged = &privileged
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("container %s is privileged", container.Name))
+	}
+	return violations
+}
+
+func checkHostNetwork(spec *api.PodSpec, fix bool) []string {
+	if spec.SecurityContext == nil || !spec.SecurityContext.HostNetwork {
+		return nil
+	}
+	if fix {
+		spec.SecurityContext.HostNetwork = false
+		return nil
+	}
+	return []string{"pod uses the host network"}
+}
+
+func checkHostPID(spec *api.PodSpec, fix bool) []string {
+	if spec.SecurityContext == nil || !spec.SecurityContext.HostPID {
+		return nil
+	}
+	if fix {
+		spec.SecurityContext.HostPID = false
+		return nil
+	}
+	return []string{"pod uses the host PID namespace"}
+}
+
+//checkHostPath can't fix anything, removing a volume would leave mounts without one
+func checkHostPath(spec *api.PodSpec, fix bool) []string {
+	var violations []string
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			violations = append(violations, fmt.Sprintf("volume %s is a hostPath volume of %s", volume.Name, volume.HostPath.Path))
+		}
+	}
+	return violations
+}
+
+func checkCapabilities(spec *api.PodSpec, fix bool) []string {
+	var violations []string
+	for _, container := range podContainers(spec) {
+		context := container.SecurityContext
+		if context == nil || context.Capabilities == nil || len(context.Capabilities.Add) == 0 {
+			continue
+		}
+		if fix {
+			context.Capabilities.Add = nil
+			continue
+		}
+		added := make([]string, len(context.Capabilities.Add))
+		for i, capability := range context.Capabilities.Add {
+			added[i] = string(capability)
+		}
+		violations = append(violations, fmt.Sprintf("container %s adds capabilities %s", container.Name, strings.Join(added, ", ")))
+	}
+	return violations
+}
+
+//checkRunAsNonRoot requires every container to run as a user other than root. Settings on a container
+//take precedence over those on the pod, as they do in kubernetes.
+//A container explicitly run as user 0 can't be fixed.
+func checkRunAsNonRoot(spec *api.PodSpec, fix bool) []string {
+	var violations []string
+	for _, container := range podContainers(spec) {
+		var runAsUser *int64
+		var runAsNonRoot *bool
+		if spec.SecurityContext != nil {
+			runAsUser = spec.SecurityContext.RunAsUser
+			runAsNonRoot = spec.SecurityContext.RunAsNonRoot
+		}
+		if container.SecurityContext != nil {
+			if container.SecurityContext.RunAsUser != nil {
+				runAsUser = container.SecurityContext.RunAsUser
+			}
+			if container.SecurityContext.RunAsNonRoot != nil {
+				runAsNonRoot = container.SecurityContext.RunAsNonRoot
+			}
+		}
+
+		switch {
+		case runAsUser != nil && *runAsUser == 0:
+			violations = append(violations, fmt.Sprintf("container %s runs as root", container.Name))
+		case runAsUser != nil, runAsNonRoot != nil && *runAsNonRoot:
+			//A non zero user, or the kubelet checks the image's user
+		case fix:
+			if container.SecurityContext == nil {
+				container.SecurityContext = &api.SecurityContext{}
+			}
+			nonRoot := true
+			container.SecurityContext.RunAsNonRoot = &nonRoot
+		default:
+			violations = append(violations, fmt.Sprintf("container %s may run as root, set runAsNonRoot or runAsUser", container.Name))
+		}
+	}
+	return violations
+}
+
+//checkRegistries rejects images that aren't from one of the allowed registries
+func checkRegistries(spec *api.PodSpec, allowed []string) []string {
+	var violations []string
+	for _, container := range podContainers(spec) {
+		if !imageAllowed(container.Image, allowed) {
+			violations = append(violations, fmt.Sprintf("container %s image %s isn't from an allowed registry (%s)", container.Name, container.Image, strings.Join(allowed, ", ")))
+		}
+	}
+	return violations
+}
+
+//imageAllowed is true when the image's repository is in one of the allowed registries or repository paths
+func imageAllowed(image string, allowed []string) bool {
+	repository := imageRepository(image)
+	for _, prefix := range allowed {
+		if strings.HasPrefix(repository, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+//imageRepository is the full repository of an image with its registry, the way docker resolves it.
+//Images without a registry are from docker.io, and official images are in its library.
+func imageRepository(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return image
+	}
+	if len(parts) == 1 {
+		return "docker.io/library/" + image
+	}
+	return "docker.io/" + image
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,31 +12,52 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
 	"k8s.io/kubernetes/pkg/api/unversioned"
 	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
 
-	k8sClient "k8s.io/kubernetes/pkg/client/unversioned"
+	deploymentutil "k8s.io/kubernetes/pkg/util/deployment"
 
+	"github.com/30x/enrober/pkg/apigee"
+	"github.com/30x/enrober/pkg/config"
 	"github.com/30x/enrober/pkg/helper"
+	"github.com/30x/enrober/pkg/kubeclient"
+	"github.com/30x/enrober/pkg/metrics"
 )
 
 const (
 	apigeeKVMName   = "routing"
 	apigeeKVMPKName = "public-key"
+
+	//Annotation the deployment controller copies onto each replica set
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+
+	//Longest single log line we will split into a record
+	maxLogLineLength = 1024 * 1024
+
+	//Routing secret annotation holding when the previous keys stop being accepted
+	previousKeysExpireAnnotation = "previousKeysExpire"
+
+	//How long previous routing keys are kept after a rotation by default
+	defaultKeyGracePeriod = 24 * time.Hour
 )
 
 //Global Vars
 var (
 	//Kubernetes Client
-	client k8sClient.Client
+	client kubeclient.Interface
 
 	//Global Regex
 	validIPAddressRegex = regexp.MustCompile(`^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])$`)
@@ -42,7 +66,7 @@ var (
 	//Env Name Regex
 	envNameRegex = regexp.MustCompile(`\w+\:\w+`)
 
-	//Privileged container flag
+	//Privileged container flag, without it the security policy can't allow privileged containers or host access
 	allowPrivilegedContainers bool
 
 	//Namespace Isolation
@@ -50,55 +74,208 @@ var (
 
 	//Apigee KVM check
 	apigeeKVM bool
+
+	//Name of the image pull secret of each environment
+	imagePullSecret string
+
+	//Callers must be org admins, only in PROD
+	checkOrgAdmin bool
 )
 
 //NOTE: routing secret should probably be a configurable name
 
-//NewServer creates a new server
-func NewServer() (server *Server) {
+//NewServer creates a new server from a validated config
+func NewServer(cfg *config.Config) (server *Server) {
+	//Several features should be disabled for local testing
+	checkOrgAdmin = cfg.Prod()
+	isolateNamespace = cfg.Prod() && cfg.IsolateNamespace
+	allowPrivilegedContainers = cfg.Prod() && cfg.AllowPrivilegedContainers
+	apigeeKVM = cfg.Prod() && cfg.ApigeeKVM
+	imagePullSecret = cfg.ImagePullSecret
+	SetReadinessCache(cfg.ReadinessCache.Duration)
+
+	apigeeClient = apigee.NewClient("https://" + cfg.ApigeeHost)
+
+	helper.SetPTSConfig(helper.PTSConfig{
+		RestrictHost:          cfg.Prod(),
+		ShipyardHost:          cfg.ShipyardHost,
+		InternalRouterHost:    cfg.InternalRouterHost,
+		ShipyardPrivateSecret: cfg.ShipyardPrivateSecret,
+		APIRoutingKeyHeader:   cfg.APIRoutingKeyHeader,
+	})
+
 	router := mux.NewRouter()
 
-	router.Path("/environments").Methods("POST").HandlerFunc(createEnvironment)
-	router.Path("/environments/{org}:{env}").Methods("GET").HandlerFunc(getEnvironment)
-	router.Path("/environments/{org}:{env}").Methods("PATCH").HandlerFunc(updateEnvironment)
-	router.Path("/environments/{org}:{env}").Methods("DELETE").HandlerFunc(deleteEnvironment)
-	router.Path("/environments/{org}:{env}/deployments").Methods("POST").HandlerFunc(createDeployment)
-	router.Path("/environments/{org}:{env}/deployments").Methods("GET").HandlerFunc(getDeployments)
-	router.Path("/environments/{org}:{env}/deployments/{deployment}").Methods("GET").HandlerFunc(getDeployment)
-	router.Path("/environments/{org}:{env}/deployments/{deployment}").Methods("PATCH").HandlerFunc(updateDeployment)
-	router.Path("/environments/{org}:{env}/deployments/{deployment}").Methods("DELETE").HandlerFunc(deleteDeployment)
-	router.Path("/environments/{org}:{env}/deployments/{deployment}/logs").Methods("GET").HandlerFunc(getDeploymentLogs)
+	//handle registers an API route, its metrics are labelled with the path template
+	handle := func(path, method string, handler http.HandlerFunc) {
+		router.Path(path).Methods(method).Handler(metrics.InstrumentHandler(path, handler))
+	}
+
+	handle("/environments", "POST", createEnvironment)
+	handle("/environments", "GET", getEnvironments)
+	handle("/environments/{org}:{env}", "GET", getEnvironment)
+	handle("/environments/{org}:{env}", "PATCH", updateEnvironment)
+	handle("/environments/{org}:{env}", "DELETE", deleteEnvironment)
+	handle("/environments/{org}:{env}/keys/rotate", "POST", rotateEnvironmentKeys)
+	handle("/environments/{org}:{env}/kvm/status", "GET", getKVMStatus)
+	handle("/environments/{org}:{env}/kvm/sync", "POST", syncKVM)
+	handle("/environments/{org}:{env}/events", "GET", getEnvironmentEvents)
+	handle("/environments/{org}:{env}/configs", "GET", getConfigs)
+	handle("/environments/{org}:{env}/configs/{name}", "GET", getConfig)
+	handle("/environments/{org}:{env}/configs/{name}", "PUT", putConfig)
+	handle("/environments/{org}:{env}/configs/{name}", "DELETE", deleteConfig)
+	handle("/environments/{org}:{env}/secrets", "GET", getSecrets)
+	handle("/environments/{org}:{env}/secrets/{name}", "GET", getSecret)
+	handle("/environments/{org}:{env}/secrets/{name}", "PUT", putSecret)
+	handle("/environments/{org}:{env}/secrets/{name}", "DELETE", deleteSecret)
+	handle("/environments/{org}:{env}/deployments", "POST", createDeployment)
+	handle("/environments/{org}:{env}/deployments", "GET", getDeployments)
+	handle("/environments/{org}:{env}/deployments/{deployment}", "GET", getDeployment)
+	handle("/environments/{org}:{env}/deployments/{deployment}", "PATCH", updateDeployment)
+	handle("/environments/{org}:{env}/deployments/{deployment}", "DELETE", deleteDeployment)
+	handle("/environments/{org}:{env}/deployments/{deployment}/status", "GET", getDeploymentStatus)
+	handle("/environments/{org}:{env}/deployments/{deployment}/scale", "PUT", scaleDeployment)
+	handle("/environments/{org}:{env}/deployments/{deployment}/autoscaler", "PUT", putAutoscaler)
+	handle("/environments/{org}:{env}/deployments/{deployment}/autoscaler", "GET", getAutoscaler)
+	handle("/environments/{org}:{env}/deployments/{deployment}/autoscaler", "DELETE", deleteAutoscaler)
+	handle("/environments/{org}:{env}/deployments/{deployment}/env/{name}", "GET", getEnvVar)
+	handle("/environments/{org}:{env}/deployments/{deployment}/env/{name}", "PUT", putEnvVar)
+	handle("/environments/{org}:{env}/deployments/{deployment}/env/{name}", "DELETE", deleteEnvVar)
+	handle("/environments/{org}:{env}/deployments/{deployment}/logs", "GET", getDeploymentLogs)
+	handle("/environments/{org}:{env}/deployments/{deployment}/events", "GET", getDeploymentEvents)
+	handle("/environments/{org}:{env}/deployments/{deployment}/revisions", "GET", getDeploymentRevisions)
+	handle("/environments/{org}:{env}/deployments/{deployment}/rollback", "POST", rollbackDeployment)
 
 	//health check
 	router.Path("/environments/status/").Methods("GET").HandlerFunc(getStatus)
 	router.Path("/environments/status").Methods("GET").HandlerFunc(getStatus)
+	router.Path("/environments/status/ready").Methods("GET").HandlerFunc(getReadiness)
 
-	loggedRouter := handlers.CombinedLoggingHandler(os.Stdout, router)
+	router.NotFoundHandler = http.HandlerFunc(notFound)
+
+	loggedRouter := handlers.CombinedLoggingHandler(os.Stdout, withRequestID(router))
+
+	//Health checks and metrics, kept off the API port when there's an admin listener
+	adminRouter := mux.NewRouter()
+	adminRouter.Path("/status").Methods("GET").HandlerFunc(getStatus)
+	adminRouter.Path("/environments/status").Methods("GET").HandlerFunc(getStatus)
+	adminRouter.Path("/status/ready").Methods("GET").HandlerFunc(getReadiness)
+	adminRouter.Path("/environments/status/ready").Methods("GET").HandlerFunc(getReadiness)
+	adminRouter.Path("/metrics").Methods("GET").Handler(metrics.Handler())
+	adminRouter.NotFoundHandler = http.HandlerFunc(notFound)
+
+	//Without an admin listener the metrics are served with the API
+	if cfg.AdminPort == 0 {
+		router.Path("/metrics").Methods("GET").Handler(metrics.Handler())
+	}
+
+	metrics.SetManagedCounter(countManaged)
 
 	server = &Server{
-		Router: loggedRouter,
+		Router:      loggedRouter,
+		AdminRouter: withRequestID(adminRouter),
+		config:      cfg,
 	}
 	return server
 }
 
-// Copied from https://github.com/30x/authsdk/blob/master/apigee.go#L19
-//
-// TODO: Turn this into some Go-based Apigee client/SDK to replace authsdk
-var apigeeApiHost string
+//Apigee management API client
+var apigeeClient *apigee.Client
 
-func init() {
-	envVar := os.Getenv("AUTH_API_HOST")
+//SetApigeeClient replaces the Apigee management API client, tests use it to point at a fake
+func SetApigeeClient(c *apigee.Client) {
+	apigeeClient = c
+}
 
-	if envVar == "" {
-		apigeeApiHost = "api.enterprise.apigee.com"
-	} else {
-		apigeeApiHost = envVar
-	}
+//SetApigeeKVM turns keeping routing keys in Apigee KVMs on or off, tests use it to turn it on outside of PROD
+func SetApigeeKVM(enabled bool) {
+	apigeeKVM = enabled
 }
 
-//Start the server
-func (server *Server) Start() error {
-	return http.ListenAndServe(":9000", server.Router)
+//Start serves the API, and the admin listener when configured, until ctx is done.
+//In flight requests then get the shutdown timeout to finish so an environment isn't left half created,
+//followed log and event streams are ended right away.
+func (server *Server) Start(ctx context.Context) error {
+	cfg := server.config
+
+	apiServer := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
+		Handler:      server.Router,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+	}
+	//Streams would otherwise only end with the client
+	apiServer.RegisterOnShutdown(streams.cancelAll)
+
+	servers := []*http.Server{apiServer}
+	if cfg.AdminPort != 0 {
+		servers = append(servers, &http.Server{
+			Addr:         fmt.Sprintf(":%d", cfg.AdminPort),
+			Handler:      server.AdminRouter,
+			ReadTimeout:  cfg.ReadTimeout.Duration,
+			WriteTimeout: cfg.WriteTimeout.Duration,
+		})
+	}
+
+	if cfg.TLSCertFile != "" {
+		reloader, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		go reloader.run(ctx, certReloadInterval)
+		apiServer.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	}
+
+	//Previous routing keys are expired from their annotation so a restart doesn't keep them
+	if cfg.KeyExpiryInterval.Duration > 0 {
+		go runKeyExpirer(ctx, cfg.KeyExpiryInterval.Duration)
+	}
+
+	//Optionally keep the Apigee KVMs in line with the routing secrets
+	if apigeeKVM && cfg.KVMReconcileInterval.Duration > 0 {
+		go runKVMReconciler(ctx, cfg.KVMReconcileInterval.Duration, cfg.KVMReconcileAuthorization, cfg.KVMReconcileFix)
+	}
+
+	serveErrors := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				serveErrors <- err
+			}
+		}(srv)
+		helper.LogInfo.Printf("Listening on %s\n", srv.Addr)
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		helper.LogInfo.Printf("Shutting down, waiting up to %v for requests to finish\n", cfg.ShutdownTimeout)
+	case serveErr = <-serveErrors:
+		helper.LogError.Printf("Error serving: %v\n", serveErr)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	for _, srv := range servers {
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			helper.LogError.Printf("Error shutting down %s: %v\n", srv.Addr, err)
+			if serveErr == nil {
+				serveErr = err
+			}
+		}
+	}
+	return serveErr
 }
 
 //createEnvironment creates a kubernetes namespace and secret
@@ -108,14 +285,14 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 	var tempJSON environmentPost
 	err := json.NewDecoder(r.Body).Decode(&tempJSON)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		helper.WriteError(w, err.Error(), http.StatusBadRequest)
 		helper.LogError.Printf("Error decoding JSON Body: %s\n", err)
 		return
 	}
 
 	//Make sure they passed a valid environment name of form {org}:{env}
 	if !envNameRegex.MatchString(tempJSON.EnvironmentName) {
-		http.Error(w, "Invalid environment name", http.StatusInternalServerError)
+		helper.WriteError(w, "Invalid environment name", http.StatusBadRequest)
 		helper.LogError.Printf("Not a valid environment name: %s\n", tempJSON.EnvironmentName)
 		return
 	}
@@ -125,7 +302,7 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 	apigeeOrgName := nameSlice[0]
 	apigeeEnvName := nameSlice[1]
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(apigeeOrgName, w, r) {
 			return
 		}
@@ -144,7 +321,7 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 
 		if !(validIP || validHost) {
 			//Regex didn't match
-			http.Error(w, "Invalid Hostname", http.StatusInternalServerError)
+			helper.WriteError(w, "Invalid Hostname", http.StatusBadRequest)
 			helper.LogError.Printf("Not a valid hostname: %s\n", value)
 			return
 		}
@@ -158,13 +335,13 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 	uniqueHosts, err := helper.UniqueHostNames(tempJSON.HostNames, client)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error in UniqueHostNames: %v", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage + "\n")
 		return
 	}
 	if !uniqueHosts {
 		errorMessage := "Duplicate HostNames"
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusConflict)
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -174,127 +351,27 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 	publicKey, err := helper.GenerateRandomString(32)
 	if err != nil {
 		helper.LogError.Printf("Error generating random string: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		helper.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	//Should attempt KVM creation before creating k8s objects
 	if apigeeKVM {
+		kvm := routingKVM([]byte(publicKey))
 
-		httpClient := &http.Client{}
-
-		//construct URL
-		apigeeKVMURL := fmt.Sprintf("https://%s/v1/organizations/%s/environments/%s/keyvaluemaps", apigeeApiHost, apigeeOrgName, apigeeEnvName)
-
-		//create JSON body
-		kvmBody := apigeeKVMBody{
-			Name: apigeeKVMName,
-			Entry: []apigeeKVMEntry{
-				apigeeKVMEntry{
-					Name:  apigeeKVMPKName,
-					Value: base64.StdEncoding.EncodeToString([]byte(publicKey)),
-				},
-			},
-		}
-
-		b := new(bytes.Buffer)
-		json.NewEncoder(b).Encode(kvmBody)
+		err = apigeeClient.CreateKVM(r.Header.Get("Authorization"), apigeeOrgName, apigeeEnvName, kvm)
 
-		req, err := http.NewRequest("POST", apigeeKVMURL, b)
-		if err != nil {
-			errorMessage := fmt.Sprintf("Unable to create request (Create KVM): %v", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
-			helper.LogError.Printf(errorMessage + "\n")
-			return
+		// If the KVM already exists, we need to update its value(s).
+		if apigee.IsConflict(err) {
+			err = apigeeClient.UpdateKVMEntries(r.Header.Get("Authorization"), apigeeOrgName, apigeeEnvName, kvm)
 		}
 
-		//Must pass through the authz header
-		req.Header.Add("Authorization", r.Header.Get("Authorization"))
-		req.Header.Add("Content-Type", "application/json")
-
-		resp, err := httpClient.Do(req)
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error creating Apigee KVM: %v", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+			helper.WriteError(w, errorMessage, apigeeErrorStatus(err))
 			helper.LogError.Printf(errorMessage + "\n")
 			return
 		}
-		defer resp.Body.Close()
-
-		// If the response was not a 201, we need to check if the response was a 409 because this means the KVM exists
-		// already and we'll need to update the KVM value(s).
-		if resp.StatusCode != 201 {
-			var retryFlag bool
-
-			// If the KVM already exists, we need to update its value(s).
-			if resp.StatusCode == 409 {
-				b2 := new(bytes.Buffer)
-				updateKVMURL := fmt.Sprintf("%s/%s", apigeeKVMURL, apigeeKVMName) // Use non-CPS endpoint by default
-
-				if isCPSEnabledForOrg(apigeeOrgName, r.Header.Get("Authorization")) {
-					// When using CPS, the API endpoint is different and instead of sending the whole KVM body, we can only send
-					// the KVM entry to update.  (This will work for now since we are only persisting one key but in the future
-					// we might need to update this to make N calls, one per key.)
-					updateKVMURL = fmt.Sprintf("%s/entries/%s", updateKVMURL, apigeeKVMPKName)
-
-					json.NewEncoder(b2).Encode(kvmBody.Entry[0])
-				} else {
-					// When not using CPS, send the whole KVM body to update all keys in the KVM.
-					json.NewEncoder(b2).Encode(kvmBody) // Non-CPS takes the whole payload
-				}
-
-				updateKVMReq, err := http.NewRequest("POST", updateKVMURL, b2)
-
-				if err != nil {
-					errorMessage := fmt.Sprintf("Unable to create request (Update KVM): %v", err)
-					http.Error(w, errorMessage, http.StatusInternalServerError)
-					helper.LogError.Printf(errorMessage + "\n")
-					return
-				}
-
-				fmt.Printf("The update KVM URL: %v\n", updateKVMReq.URL.String())
-
-				updateKVMReq.Header.Add("Authorization", r.Header.Get("Authorization"))
-				updateKVMReq.Header.Add("Content-Type", "application/json")
-
-				resp2, err := httpClient.Do(updateKVMReq)
-				if err != nil {
-					errorMessage := fmt.Sprintf("Error creating entry in existing Apigee KVM: %v", err)
-					http.Error(w, errorMessage, http.StatusInternalServerError)
-					helper.LogError.Printf(errorMessage + "\n")
-					return
-				}
-				defer resp2.Body.Close()
-
-				var updateKVMRes retryResponse
-
-				//Decode response
-				err = json.NewDecoder(resp2.Body).Decode(&updateKVMRes)
-
-				if err != nil {
-					fmt.Printf("Failed to decode response: %v\n", err)
-					http.Error(w, "Failed to decode response", http.StatusInternalServerError)
-					return
-				}
-
-				// Updating a KVM returns a 200 on success so if it's not a 200, it's a failure
-				if resp2.StatusCode != 200 {
-					errorMessage := fmt.Sprintf("Couldn't create KVM entry (Status Code: %d): %v", resp2.StatusCode, updateKVMRes.Message)
-					http.Error(w, errorMessage, http.StatusInternalServerError)
-					helper.LogError.Printf(errorMessage + "\n")
-					return
-				}
-
-				retryFlag = true
-			}
-
-			if !retryFlag {
-				errorMessage := fmt.Sprintf("Expected 201 or 409, got: %v", resp.StatusCode)
-				http.Error(w, errorMessage, http.StatusInternalServerError)
-				helper.LogError.Printf(errorMessage + "\n")
-				return
-			}
-		}
-
 	}
 
 	//Should create an annotation object and pass it into the object literal
@@ -324,7 +401,7 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 	createdNs, err := client.Namespaces().Create(nsObject)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error creating namespace: %v", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage + "\n")
 		return
 	}
@@ -345,7 +422,7 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 	//Create Secret
 	secret, err := client.Secrets(tempJSON.EnvironmentName).Create(&tempSecret)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		helper.WriteError(w, err.Error(), kubeErrorStatus(err))
 		helper.LogError.Printf("Error creating secret: %s\n", err)
 
 		err = client.Namespaces().Delete(createdNs.GetName())
@@ -367,7 +444,7 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 
 	js, err := json.Marshal(jsResponse)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		helper.WriteError(w, err.Error(), http.StatusInternalServerError)
 		helper.LogError.Printf("Error marshalling response JSON: %s\n", err)
 		return
 	}
@@ -384,7 +461,7 @@ func createEnvironment(w http.ResponseWriter, r *http.Request) {
 func getEnvironment(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			return
 		}
@@ -392,14 +469,14 @@ func getEnvironment(w http.ResponseWriter, r *http.Request) {
 
 	getNs, err := client.Namespaces().Get(pathVars["org"] + "-" + pathVars["env"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		helper.WriteError(w, err.Error(), kubeErrorStatus(err))
 		helper.LogError.Printf("Error getting existing Environment: %v\n", err)
 		return
 	}
 
 	getSecret, err := client.Secrets(pathVars["org"] + "-" + pathVars["env"]).Get("routing")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		helper.WriteError(w, err.Error(), kubeErrorStatus(err))
 		helper.LogError.Printf("Error getting existing Secret: %v\n", err)
 		return
 	}
@@ -412,7 +489,7 @@ func getEnvironment(w http.ResponseWriter, r *http.Request) {
 
 	js, err := json.Marshal(jsResponse)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		helper.WriteError(w, err.Error(), http.StatusInternalServerError)
 		helper.LogError.Printf("Error marshalling response JSON: %v\n", err)
 		return
 	}
@@ -424,11 +501,131 @@ func getEnvironment(w http.ResponseWriter, r *http.Request) {
 	helper.LogInfo.Printf("Got Namespace: %s\n", getNs.GetName())
 }
 
+//getEnvironments lists the environments enrober created, optionally only those of one org.
+//Callers only see the orgs they are an org admin of, and routing keys only with includeSecrets=true.
+func getEnvironments(w http.ResponseWriter, r *http.Request) {
+	queries := r.URL.Query()
+
+	includeSecretsString := queries.Get("includeSecrets")
+	var includeSecrets bool
+	if includeSecretsString != "" {
+		var err error
+		includeSecrets, err = strconv.ParseBool(includeSecretsString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid includeSecrets value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	nsLabels := labels.Set{"Runtime": "shipyard"}
+	if org := queries.Get("org"); org != "" {
+		nsLabels["Organziation"] = org
+	}
+
+	nsList, err := client.Namespaces().List(api.ListOptions{
+		LabelSelector: labels.SelectorFromSet(nsLabels),
+	})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving environment list: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	namespaces := nsList.Items
+	if checkOrgAdmin {
+		orgs := []string{}
+		seen := map[string]bool{}
+		for _, ns := range namespaces {
+			org := ns.Labels["Organziation"]
+			if !seen[org] {
+				seen[org] = true
+				orgs = append(orgs, org)
+			}
+		}
+
+		adminOrgs, ok := helper.AdminOrganizations(orgs, w, r)
+		if !ok {
+			return
+		}
+		allowed := map[string]bool{}
+		for _, org := range adminOrgs {
+			allowed[org] = true
+		}
+
+		namespaces = []api.Namespace{}
+		for _, ns := range nsList.Items {
+			if allowed[ns.Labels["Organziation"]] {
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+
+	//One list across every namespace is cheaper than one per environment
+	depList, err := client.Deployments(api.NamespaceAll).List(api.ListOptions{})
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment list: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	deploymentCounts := map[string]int{}
+	for _, dep := range depList.Items {
+		deploymentCounts[dep.Namespace]++
+	}
+
+	respList := environmentList{
+		Items: []environmentSummary{},
+	}
+	for _, ns := range namespaces {
+		env := environmentSummary{
+			Name:         ns.Name,
+			Organization: ns.Labels["Organziation"],
+			Environment:  ns.Labels["Environment"],
+			Deployments:  deploymentCounts[ns.Name],
+		}
+		if ns.Annotations["hostNames"] != "" {
+			env.HostNames = strings.Split(ns.Annotations["hostNames"], " ")
+		}
+
+		if includeSecrets {
+			getSecret, err := client.Secrets(ns.Name).Get("routing")
+			if err != nil {
+				errorMessage := fmt.Sprintf("Error getting routing secret of %s: %v\n", ns.Name, err)
+				helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+				helper.LogError.Printf(errorMessage)
+				return
+			}
+			env.PublicSecret = getSecret.Data["public-api-key"]
+			env.PrivateSecret = getSecret.Data["private-api-key"]
+		}
+
+		respList.Items = append(respList.Items, env)
+	}
+	sort.Sort(environmentsByName(respList.Items))
+
+	js, err := json.Marshal(respList)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling environment list: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Listed %d Environments\n", len(respList.Items))
+}
+
 //updateEnvironment modifies the hostNames array on an existing environment
 func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			return
 		}
@@ -439,7 +636,7 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errorMessage := fmt.Sprintf("Namespace %s doesn't exist\n", pathVars["org"]+"-"+pathVars["env"])
 		helper.LogError.Printf(errorMessage)
-		http.Error(w, errorMessage, http.StatusNotFound)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		return
 	}
 
@@ -448,7 +645,7 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errorMessage := fmt.Sprintf("Failed to get existing routing secret on %s namespace\n", pathVars["org"]+"-"+pathVars["env"])
 		helper.LogError.Printf(errorMessage)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		return
 	}
 
@@ -458,7 +655,7 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
 		helper.LogError.Printf(errorMessage)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
 		return
 	}
 
@@ -473,7 +670,7 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 
 		if !(validIP || validHost) {
 			//Regex didn't match
-			http.Error(w, "Invalid Hostname", http.StatusInternalServerError)
+			helper.WriteError(w, "Invalid Hostname", http.StatusBadRequest)
 			helper.LogError.Printf("Not a valid hostname: %s\n", value)
 			return
 		}
@@ -493,13 +690,13 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 	uniqueHosts, err := helper.UniqueHostNames(tempJSON.HostNames, client)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error in UniqueHostNames: %v", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
 		return
 	}
 	if !uniqueHosts {
 		errorMessage := "Duplicate HostNames"
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusConflict)
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -510,7 +707,7 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errorMessage := fmt.Sprintf("Failed to update existing namespace '%s'\n", getNs)
 		helper.LogError.Printf(errorMessage)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		return
 	}
 	helper.LogInfo.Printf("Updated hostNames: %s\n", updateNS.Annotations["hostNames"])
@@ -524,8 +721,9 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 	js, err := json.Marshal(jsResponse)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Couldn't marshall namespace: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
@@ -537,138 +735,517 @@ func updateEnvironment(w http.ResponseWriter, r *http.Request) {
 func deleteEnvironment(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			return
 		}
 	}
 
-	err := client.Namespaces().Delete(pathVars["org"] + "-" + pathVars["env"])
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	keepKVMString := r.URL.Query().Get("keepKVM")
+	var keepKVM bool
+	if keepKVMString != "" {
+		var err error
+		keepKVM, err = strconv.ParseBool(keepKVMString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid keepKVM value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	//Nothing is touched in Apigee for an environment that doesn't exist
+	_, err := client.Namespaces().Get(namespace)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error in deleteEnvironment: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
-	w.WriteHeader(204)
 
-	helper.LogInfo.Printf("Deleted Namespace: %s\n", pathVars["org"]+"-"+pathVars["env"])
-}
-
-//getDeployments returns a list of all deployments matching the given org and env name
-func getDeployments(w http.ResponseWriter, r *http.Request) {
-	pathVars := mux.Vars(r)
+	deletion := environmentDeletion{
+		Name:    namespace,
+		Deleted: []string{},
+	}
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
-		if !helper.ValidAdmin(pathVars["org"], w, r) {
-			return
+	//The KVM goes first so a failure leaves the namespace for a retry to find.
+	//A KVM that's already gone isn't an error, so retrying after the namespace failed works too.
+	if apigeeKVM {
+		if keepKVM {
+			deletion.Skipped = append(deletion.Skipped, "kvm")
+		} else {
+			err := apigeeClient.RemoveKVM(r.Header.Get("Authorization"), pathVars["org"], pathVars["env"], apigeeKVMName)
+			if err != nil {
+				helper.LogError.Printf("Error removing Apigee KVM for %s: %v\n", namespace, err)
+				deletion.Failed = map[string]string{"kvm": err.Error()}
+				writeEnvironmentDeletion(w, deletion, fmt.Sprintf("Couldn't remove the Apigee KVM of %s, nothing was deleted", namespace), apigeeErrorStatus(err))
+				return
+			}
+			deletion.Deleted = append(deletion.Deleted, "kvm")
+			helper.LogInfo.Printf("Removed Apigee KVM for %s\n", namespace)
 		}
 	}
 
-	depList, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).List(api.ListOptions{
-		LabelSelector: labels.Everything(),
-	})
+	err = client.Namespaces().Delete(namespace)
 	if err != nil {
-		errorMessage := fmt.Sprintf("Error retrieving deployment list: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		errorMessage := fmt.Sprintf("Error in deleteEnvironment: %v\n", err)
 		helper.LogError.Printf(errorMessage)
+		if len(deletion.Deleted) == 0 {
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+			return
+		}
+		//Report what was removed so the caller knows the KVM is gone
+		deletion.Failed = map[string]string{"namespace": err.Error()}
+		writeEnvironmentDeletion(w, deletion, fmt.Sprintf("Removed the Apigee KVM of %s but not its namespace", namespace), kubeErrorStatus(err))
 		return
 	}
-	js, err := json.Marshal(depList)
+	helper.LogInfo.Printf("Deleted Namespace: %s\n", namespace)
+
+	w.WriteHeader(204)
+}
+
+//writeEnvironmentDeletion reports an environment that was only partly deleted so the caller can clean it up
+func writeEnvironmentDeletion(w http.ResponseWriter, deletion environmentDeletion, message string, status int) {
+	deletion.ErrorResponse = helper.NewErrorResponse(w, message, status)
+	js, err := json.Marshal(deletion)
 	if err != nil {
-		errorMessage := fmt.Sprintf("Error marshalling deployment list: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		errorMessage := fmt.Sprintf("Error marshaling environment deletion: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
+	w.WriteHeader(status)
 	w.Write(js)
-	for _, value := range depList.Items {
-		helper.LogInfo.Printf("Got Deployment: %s\n", value.GetName())
-	}
 }
 
-//createDeployment creates a deployment in the given environment(namespace) with the given environmentGroupID based on the given deploymentBody
-func createDeployment(w http.ResponseWriter, r *http.Request) {
+//rotateEnvironmentKeys generates a new public and private key for an environment's routing secret.
+//The previous keys are kept next to the new ones until the grace period expires.
+func rotateEnvironmentKeys(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			return
 		}
 	}
 
-	//Decode passed JSON body
-	var tempJSON deploymentPost
+	//Body is optional, an empty one means the default grace period
+	tempJSON := keyRotationPost{}
 	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil && err != io.EOF {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	gracePeriod := defaultKeyGracePeriod
+	if tempJSON.GracePeriodSeconds != nil {
+		if *tempJSON.GracePeriodSeconds < 0 {
+			errorMessage := fmt.Sprintf("Invalid gracePeriodSeconds: %d\n", *tempJSON.GracePeriodSeconds)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+		gracePeriod = time.Duration(*tempJSON.GracePeriodSeconds) * time.Second
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+
+	//Get the existing routing secret
+	getSecret, err := client.Secrets(namespace).Get("routing")
 	if err != nil {
-		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		errorMessage := fmt.Sprintf("Failed to get existing routing secret on %s namespace\n", namespace)
 		helper.LogError.Printf(errorMessage)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		return
 	}
 
-	if tempJSON.PublicHosts == nil && tempJSON.PrivateHosts == nil {
-		errorMessage := fmt.Sprintf("No privateHosts or publicHosts given\n")
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+	//Replacing previous keys that are still valid would cut off routers holding them with no grace period
+	if _, ok := getSecret.Data["public-api-key-previous"]; ok && !previousKeysExpired(getSecret) {
+		errorMessage := fmt.Sprintf("Previous routing keys of %s are valid until %s, rotate again after they expire\n", namespace, getSecret.Annotations[previousKeysExpireAnnotation])
+		helper.WriteError(w, errorMessage, http.StatusConflict)
 		helper.LogError.Printf(errorMessage)
 		return
 	}
 
-	tempPTS := api.PodTemplateSpec{}
+	//Generate both a public and private key
+	privateKey, err := helper.GenerateRandomString(32)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error generating random string: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	publicKey, err := helper.GenerateRandomString(32)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error generating random string: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
 
-	//Check if we got a URL or a direct PTS
-	if tempJSON.PTS == nil {
-		//No PTS so check ptsURL
-		if tempJSON.PtsURL == "" {
-			//No URL either so error
-			errorMessage := fmt.Sprintf("No ptsURL or PTS given\n")
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+	oldPublicKey := getSecret.Data["public-api-key"]
+
+	//Update the KVM first so a failure there leaves the secret untouched
+	if apigeeKVM {
+		err = apigeeClient.UpdateKVMEntries(r.Header.Get("Authorization"), pathVars["org"], pathVars["env"], routingKVM([]byte(publicKey)))
+		if apigee.IsNotFound(err) {
+			//Environments created before APIGEE_KVM was turned on have no KVM yet
+			err = apigeeClient.CreateKVM(r.Header.Get("Authorization"), pathVars["org"], pathVars["env"], routingKVM([]byte(publicKey)))
+		}
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error updating Apigee KVM: %v\n", err)
+			helper.WriteError(w, errorMessage, apigeeErrorStatus(err))
 			helper.LogError.Printf(errorMessage)
 			return
 		}
+	}
 
-		tempPTS, err = helper.GetPTSFromURL(tempJSON.PtsURL, r)
-		if err != nil {
-			helper.LogError.Printf(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	expires := unversioned.NewTime(time.Now().Add(gracePeriod))
 
-	} else {
-		//We got a direct PTS so just copy it
-		tempPTS = *tempJSON.PTS
+	if getSecret.Data == nil {
+		getSecret.Data = make(map[string][]byte)
+	}
+	if getSecret.Annotations == nil {
+		getSecret.Annotations = make(map[string]string)
 	}
 
-	if allowPrivilegedContainers == false {
-		for _, val := range tempPTS.Spec.Containers {
-			if val.SecurityContext != nil {
-				val.SecurityContext.Privileged = func() *bool { b := false; return &b }()
+	getSecret.Data["public-api-key-previous"] = getSecret.Data["public-api-key"]
+	getSecret.Data["private-api-key-previous"] = getSecret.Data["private-api-key"]
+	getSecret.Data["public-api-key"] = []byte(publicKey)
+	getSecret.Data["private-api-key"] = []byte(privateKey)
+	getSecret.Annotations[previousKeysExpireAnnotation] = expires.UTC().Format(time.RFC3339)
+
+	secret, err := client.Secrets(namespace).Update(getSecret)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error updating routing secret: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+
+		//Put the old public key back so the KVM matches the secret again
+		if apigeeKVM {
+			err = apigeeClient.UpdateKVMEntries(r.Header.Get("Authorization"), pathVars["org"], pathVars["env"], routingKVM(oldPublicKey))
+			if err != nil {
+				helper.LogError.Printf("Failed to restore Apigee KVM after secret update error: %v\n", err)
+				return
 			}
+			helper.LogError.Printf("Restored Apigee KVM due to secret update error\n")
 		}
+		return
 	}
 
-	tempPTS.Spec.Containers[0].Env = helper.CacheEnvVars(tempPTS.Spec.Containers[0].Env, tempJSON.EnvVars)
+	//Drop the previous keys once the grace period is over, runKeyExpirer catches them if this process doesn't last that long
+	time.AfterFunc(gracePeriod, func() {
+		expirePreviousKeys(namespace)
+	})
 
-	//If map is empty then we need to make it
-	if len(tempPTS.Annotations) == 0 {
-		tempPTS.Annotations = make(map[string]string)
-	}
+	var jsResponse keyRotationResponse
+	jsResponse.Name = namespace
+	jsResponse.PrivateSecret = secret.Data["private-api-key"]
+	jsResponse.PublicSecret = secret.Data["public-api-key"]
+	jsResponse.PreviousKeysExpire = expires
 
-	if tempJSON.PrivateHosts != nil {
-		tempPTS.Annotations["privateHosts"] = *tempJSON.PrivateHosts
+	js, err := json.Marshal(jsResponse)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling response JSON: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
 	}
 
-	if tempJSON.PublicHosts != nil {
-		tempPTS.Annotations["publicHosts"] = *tempJSON.PublicHosts
-	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
 
-	//If map is empty then we need to make it
-	if len(tempPTS.Labels) == 0 {
-		tempPTS.Labels = make(map[string]string)
+	helper.LogInfo.Printf("Rotated routing keys for Namespace: %s\n", namespace)
+}
+
+//previousKeysExpired is true when a routing secret has previous keys whose grace period is over.
+//An expiry that can't be parsed counts as over so the keys can't be kept forever.
+func previousKeysExpired(secret *api.Secret) bool {
+	expireString, ok := secret.Annotations[previousKeysExpireAnnotation]
+	if !ok {
+		return false
 	}
 
-	//Add routable label
-	tempPTS.Labels["routable"] = "true"
+	//A newer rotation may have pushed the expiry out
+	expires, err := time.Parse(time.RFC3339, expireString)
+	return err != nil || !time.Now().Before(expires)
+}
+
+//expirePreviousKeys removes the previous routing keys from a namespace's routing secret once they have expired
+func expirePreviousKeys(namespace string) {
+	getSecret, err := client.Secrets(namespace).Get("routing")
+	if err != nil {
+		helper.LogError.Printf("Error getting routing secret to expire previous keys: %v\n", err)
+		return
+	}
+
+	if !previousKeysExpired(getSecret) {
+		return
+	}
+
+	delete(getSecret.Data, "public-api-key-previous")
+	delete(getSecret.Data, "private-api-key-previous")
+	delete(getSecret.Annotations, previousKeysExpireAnnotation)
+
+	_, err = client.Secrets(namespace).Update(getSecret)
+	if err != nil {
+		helper.LogError.Printf("Error expiring previous routing keys: %v\n", err)
+		return
+	}
+	helper.LogInfo.Printf("Expired previous routing keys for Namespace: %s\n", namespace)
+}
+
+//sweepPreviousKeys expires the previous keys of the routing secret of every shipyard environment past its grace period.
+//The timer set on rotation is lost when the process stops, this catches the keys it would have expired.
+func sweepPreviousKeys() {
+	selector, err := labels.Parse("Runtime=shipyard")
+	if err != nil {
+		helper.LogError.Printf("Error parsing label selector: %v\n", err)
+		return
+	}
+
+	nsList, err := client.Namespaces().List(api.ListOptions{LabelSelector: selector})
+	if err != nil {
+		helper.LogError.Printf("Error listing namespaces to expire previous keys: %v\n", err)
+		return
+	}
+
+	for _, ns := range nsList.Items {
+		getSecret, err := client.Secrets(ns.Name).Get("routing")
+		if err != nil {
+			helper.LogError.Printf("Error getting routing secret on %s namespace to expire previous keys: %v\n", ns.Name, err)
+			continue
+		}
+		if previousKeysExpired(getSecret) {
+			expirePreviousKeys(ns.Name)
+		}
+	}
+}
+
+//runKeyExpirer calls sweepPreviousKeys right away and then every interval until ctx is done
+func runKeyExpirer(ctx context.Context, interval time.Duration) {
+	sweepPreviousKeys()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sweepPreviousKeys()
+		}
+	}
+}
+
+//getDeployments returns a list of all deployments matching the given org and env name
+func getDeployments(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	list, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error in getDeployments: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	depList, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).List(list.options)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment list: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	page, cont := list.pageDeployments(depList.Items)
+
+	respList := deploymentList{}
+	respList.Metadata.ResourceVersion = depList.ResourceVersion
+	respList.Metadata.Continue = cont
+	if list.viewMode == "summary" {
+		autoscalers, err := deploymentAutoscalers(pathVars["org"] + "-" + pathVars["env"])
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error retrieving autoscalers: %v\n", err)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+
+		summaries := []deploymentResponse{}
+		for _, dep := range page {
+			summary := summarizeDeployment(pathVars["org"]+"-"+pathVars["env"], dep)
+			summary.Autoscaler = autoscalers[dep.Name]
+			summaries = append(summaries, summary)
+		}
+		respList.Items = summaries
+	} else {
+		respList.Items = page
+	}
+
+	js, err := json.Marshal(respList)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling deployment list: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+	for _, value := range page {
+		helper.LogInfo.Printf("Got Deployment: %s\n", value.GetName())
+	}
+}
+
+//createDeployment creates a deployment in the given environment(namespace) with the given environmentGroupID based on the given deploymentBody
+func createDeployment(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	dryRunString := r.URL.Query().Get("dryRun")
+	var dryRun bool
+	if dryRunString != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(dryRunString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid dryRun value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	//Decode passed JSON body
+	var tempJSON deploymentPost
+	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %s\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if tempJSON.PublicHosts == nil && tempJSON.PrivateHosts == nil {
+		errorMessage := fmt.Sprintf("No privateHosts or publicHosts given\n")
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if tempJSON.Replicas == nil {
+		errorMessage := fmt.Sprintf("No replicas given\n")
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	err = checkReplicas(pathVars["org"]+"-"+pathVars["env"], *tempJSON.Replicas)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error creating deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, replicasErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	tempPTS := api.PodTemplateSpec{}
+
+	//Check if we got a URL or a direct PTS
+	if tempJSON.PTS == nil {
+		//No PTS so check ptsURL
+		if tempJSON.PtsURL == "" {
+			//No URL either so error
+			errorMessage := fmt.Sprintf("No ptsURL or PTS given\n")
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+
+		tempPTS, err = helper.GetPTSFromURL(tempJSON.PtsURL, r)
+		if err != nil {
+			helper.LogError.Printf(err.Error())
+			helper.WriteError(w, err.Error(), ptsErrorStatus(err))
+			return
+		}
+
+	} else {
+		//We got a direct PTS so just copy it
+		tempPTS = *tempJSON.PTS
+	}
+
+	if tempJSON.Configs != nil {
+		invalid, err := mountConfigs(pathVars["org"]+"-"+pathVars["env"], &tempPTS.Spec, tempJSON.Configs, false)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error mounting configs: %v\n", err)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+		if len(invalid) > 0 {
+			errorMessage := fmt.Sprintf("Invalid configs\n")
+			helper.WriteError(w, errorMessage, http.StatusBadRequest, invalid...)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	err = setConfigChecksum(pathVars["org"]+"-"+pathVars["env"], &tempPTS)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error reading configs: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	err = applyPolicies(pathVars["org"]+"-"+pathVars["env"], &tempPTS.Spec)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error creating deployment: %v\n", err)
+		status, details := policyErrorStatus(err)
+		helper.WriteError(w, errorMessage, status, details...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	tempPTS.Spec.Containers[0].Env = helper.CacheEnvVars(tempPTS.Spec.Containers[0].Env, tempJSON.EnvVars)
+
+	//If map is empty then we need to make it
+	if len(tempPTS.Annotations) == 0 {
+		tempPTS.Annotations = make(map[string]string)
+	}
+
+	if tempJSON.PrivateHosts != nil {
+		tempPTS.Annotations["privateHosts"] = *tempJSON.PrivateHosts
+	}
+
+	if tempJSON.PublicHosts != nil {
+		tempPTS.Annotations["publicHosts"] = *tempJSON.PublicHosts
+	}
+
+	//If map is empty then we need to make it
+	if len(tempPTS.Labels) == 0 {
+		tempPTS.Labels = make(map[string]string)
+	}
+
+	//Add routable label
+	tempPTS.Labels["routable"] = "true"
 
 	//Could also use proto package
 	tempInt := int32(5)
@@ -697,7 +1274,37 @@ func createDeployment(w http.ResponseWriter, r *http.Request) {
 	if len(depList.Items) != 0 {
 		errorMessage := fmt.Sprintf("LabelSelector " + labelSelector.String() + " already exists")
 		helper.LogError.Printf(errorMessage)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusConflict)
+		return
+	}
+
+	//A dry run stops short of creating, but a name that's taken fails like it would
+	if dryRun {
+		_, err = client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(tempJSON.DeploymentName)
+		if err == nil {
+			errorMessage := fmt.Sprintf("Deployment %s already exists\n", tempJSON.DeploymentName)
+			helper.WriteError(w, errorMessage, http.StatusConflict)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+		if !errors.IsNotFound(err) {
+			errorMessage := fmt.Sprintf("Error checking for an existing deployment: %s\n", err)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+
+		js, err := json.Marshal(&template)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error marshalling deployment: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write(js)
+		helper.LogInfo.Printf("Dry run of creating Deployment: %s\n", template.GetName())
 		return
 	}
 
@@ -705,15 +1312,16 @@ func createDeployment(w http.ResponseWriter, r *http.Request) {
 	dep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Create(&template)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error creating deployment: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
 	js, err := json.Marshal(dep)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error marshalling deployment: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
+		return
 	}
 
 	//Create absolute path for Location header
@@ -730,7 +1338,7 @@ func createDeployment(w http.ResponseWriter, r *http.Request) {
 func getDeployment(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			//Errors should be returned from function
 			return
@@ -740,15 +1348,28 @@ func getDeployment(w http.ResponseWriter, r *http.Request) {
 	getDep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	jsResponse := deploymentWithAutoscaler{Deployment: getDep}
+	hpa, err := client.HorizontalPodAutoscalers(pathVars["org"] + "-" + pathVars["env"]).Get(getDep.Name)
+	if err == nil {
+		jsResponse.Autoscaler = newAutoscalerResponse(hpa)
+	} else if !errors.IsNotFound(err) {
+		errorMessage := fmt.Sprintf("Error getting autoscaler: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
-	js, err := json.Marshal(getDep)
+
+	js, err := json.Marshal(jsResponse)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error marshalling deployment: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
@@ -762,26 +1383,51 @@ func updateDeployment(w http.ResponseWriter, r *http.Request) {
 
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			return
 		}
 	}
 
+	dryRunString := r.URL.Query().Get("dryRun")
+	var dryRun bool
+	if dryRunString != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(dryRunString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid dryRun value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
 	//Get the old namespace first so we can fail quickly if it's not there
 	getDep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error getting existing deployment: %s\n", err)
-		http.Error(w, errorMessage, http.StatusNotFound)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
+
+	//The deployment as it was, for the diff of a dry run
+	var current interface{}
+	if dryRun {
+		current, err = genericJSON(getDep)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error marshalling deployment: %v\n", err)
+			helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
 	//Decode passed JSON body
 	var tempJSON deploymentPatch
 	err = json.NewDecoder(r.Body).Decode(&tempJSON)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error decoding JSON Body: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -796,7 +1442,7 @@ func updateDeployment(w http.ResponseWriter, r *http.Request) {
 			prevDep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
 			if err != nil {
 				errorMessage := fmt.Sprintf("No ptsURL or PTS given and failed to retrieve previous PTS: %v\n", err)
-				http.Error(w, errorMessage, http.StatusInternalServerError)
+				helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 				helper.LogError.Printf(errorMessage)
 				return
 			}
@@ -806,7 +1452,8 @@ func updateDeployment(w http.ResponseWriter, r *http.Request) {
 			tempPTS, err = helper.GetPTSFromURL(tempJSON.PtsURL, r)
 			if err != nil {
 				helper.LogError.Printf(err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				helper.WriteError(w, err.Error(), ptsErrorStatus(err))
+				return
 			}
 		}
 	} else {
@@ -824,14 +1471,23 @@ func updateDeployment(w http.ResponseWriter, r *http.Request) {
 		tempPTS.Labels = make(map[string]string)
 	}
 
-	//Need to cache the previous annotations
+	//Need to cache the previous annotations, and the template for its secret env vars
 	cacheAnnotations := getDep.Spec.Template.Annotations
+	previousTemplate := getDep.Spec.Template
 
 	//Only set the replica count if the passed variable
 	if tempJSON.Replicas != nil {
+		err = checkReplicas(pathVars["org"]+"-"+pathVars["env"], *tempJSON.Replicas)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error updating deployment: %v\n", err)
+			helper.WriteError(w, errorMessage, replicasErrorStatus(err))
+			helper.LogError.Printf(errorMessage)
+			return
+		}
 		getDep.Spec.Replicas = *tempJSON.Replicas
 	}
 	getDep.Spec.Template = tempPTS
+	keepSecretEnvVars(&previousTemplate, &getDep.Spec.Template, envSecretName(getDep.Name))
 
 	//Replace the privateHosts and publicHosts annotations with cached ones
 	getDep.Spec.Template.Annotations["publicHosts"] = cacheAnnotations["publicHosts"]
@@ -850,10 +1506,73 @@ func updateDeployment(w http.ResponseWriter, r *http.Request) {
 	//Add routable label
 	getDep.Spec.Template.Labels["routable"] = "true"
 
+	//configs replaces the mounted configs, without it those mounted before are kept in a new template
+	configs, exclusive := tempJSON.Configs, true
+	if configs == nil {
+		configs, exclusive = keepConfigMounts(&previousTemplate.Spec, &getDep.Spec.Template.Spec), false
+	}
+	invalid, err := mountConfigs(pathVars["org"]+"-"+pathVars["env"], &getDep.Spec.Template.Spec, configs, exclusive)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error mounting configs: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	if len(invalid) > 0 {
+		errorMessage := fmt.Sprintf("Invalid configs\n")
+		helper.WriteError(w, errorMessage, http.StatusBadRequest, invalid...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	err = setConfigChecksum(pathVars["org"]+"-"+pathVars["env"], &getDep.Spec.Template)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error reading configs: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//Checked on every update so a tightened policy applies to the next change of a deployment
+	err = applyPolicies(pathVars["org"]+"-"+pathVars["env"], &getDep.Spec.Template.Spec)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error updating deployment: %v\n", err)
+		status, details := policyErrorStatus(err)
+		helper.WriteError(w, errorMessage, status, details...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if dryRun {
+		changes, err := diffDeployment(current, getDep)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error comparing deployment: %v\n", err)
+			helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+
+		js, err := json.Marshal(deploymentDryRun{
+			Deployment: getDep,
+			Diff:       changes,
+		})
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error marshalling deployment: %v\n", err)
+			helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write(js)
+		helper.LogInfo.Printf("Dry run of updating Deployment: %s\n", getDep.GetName())
+		return
+	}
+
 	dep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Update(getDep)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error updating deployment: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -861,8 +1580,9 @@ func updateDeployment(w http.ResponseWriter, r *http.Request) {
 	js, err := json.Marshal(dep)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error marshalling deployment: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
@@ -874,7 +1594,7 @@ func updateDeployment(w http.ResponseWriter, r *http.Request) {
 func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			return
 		}
@@ -884,7 +1604,7 @@ func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 	dep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error getting old deployment: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -893,7 +1613,7 @@ func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 	selector, err := labels.Parse("component=" + dep.Labels["component"])
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error creating label selector: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -904,7 +1624,7 @@ func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error getting replica set list: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -914,11 +1634,20 @@ func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 		LabelSelector: selector,
 	})
 
-	//Delete Deployment
+	//Delete the autoscaler first so it can't scale what is being deleted
+	err = client.HorizontalPodAutoscalers(pathVars["org"]+"-"+pathVars["env"]).Delete(pathVars["deployment"], &api.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		errorMessage := fmt.Sprintf("Error deleting autoscaler: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//Delete Deployment
 	err = client.Deployments(pathVars["org"]+"-"+pathVars["env"]).Delete(pathVars["deployment"], &api.DeleteOptions{})
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error deleting deployment: %v\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -929,7 +1658,7 @@ func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 		err = client.ReplicaSets(pathVars["org"]+"-"+pathVars["env"]).Delete(value.GetName(), &api.DeleteOptions{})
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error deleting replica set: %v\n", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 			helper.LogError.Printf(errorMessage)
 			return
 		}
@@ -941,19 +1670,28 @@ func deleteDeployment(w http.ResponseWriter, r *http.Request) {
 		err = client.Pods(pathVars["org"]+"-"+pathVars["env"]).Delete(value.GetName(), &api.DeleteOptions{})
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error deleting pod: %v\n", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 			helper.LogError.Printf(errorMessage)
 			return
 		}
 		helper.LogInfo.Printf("Deleted Pod: %v\n", value.GetName())
 	}
+
+	//Delete the secret env vars last, pods could still be starting with them until now
+	err = client.Secrets(pathVars["org"] + "-" + pathVars["env"]).Delete(envSecretName(pathVars["deployment"]))
+	if err != nil && !errors.IsNotFound(err) {
+		errorMessage := fmt.Sprintf("Error deleting env secret: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
 	w.WriteHeader(204)
 }
 
 func getDeploymentLogs(w http.ResponseWriter, r *http.Request) {
 	pathVars := mux.Vars(r)
 
-	if os.Getenv("DEPLOY_STATE") == "PROD" {
+	if checkOrgAdmin {
 		if !helper.ValidAdmin(pathVars["org"], w, r) {
 			return
 		}
@@ -968,7 +1706,7 @@ func getDeploymentLogs(w http.ResponseWriter, r *http.Request) {
 		tailInt, err := strconv.Atoi(tailString)
 		if err != nil {
 			errorMessage := fmt.Sprintf("Invalid tail value: %s\n", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
 			helper.LogError.Printf(errorMessage)
 			return
 		}
@@ -982,26 +1720,104 @@ func getDeploymentLogs(w http.ResponseWriter, r *http.Request) {
 		previous, err = strconv.ParseBool(previousString)
 		if err != nil {
 			errorMessage := fmt.Sprintf("Invalid previous value: %s\n", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	followString := queries.Get("follow")
+	var follow bool
+	if followString != "" {
+		var err error
+		follow, err = strconv.ParseBool(followString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid follow value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	sinceSecondsString := queries.Get("sinceSeconds")
+	var sinceSeconds int64 = -1
+	if sinceSecondsString != "" {
+		var err error
+		sinceSeconds, err = strconv.ParseInt(sinceSecondsString, 10, 64)
+		if err != nil || sinceSeconds < 1 {
+			errorMessage := fmt.Sprintf("Invalid sinceSeconds value: %s\n", sinceSecondsString)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	sinceTimeString := queries.Get("sinceTime")
+	var sinceTime *unversioned.Time
+	if sinceTimeString != "" {
+		parsedTime, err := time.Parse(time.RFC3339, sinceTimeString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid sinceTime value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+		tempTime := unversioned.NewTime(parsedTime)
+		sinceTime = &tempTime
+	}
+
+	if sinceSeconds != -1 && sinceTime != nil {
+		errorMessage := "Only one of sinceSeconds or sinceTime may be given\n"
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	timestampsString := queries.Get("timestamps")
+	var timestamps bool
+	if timestampsString != "" {
+		var err error
+		timestamps, err = strconv.ParseBool(timestampsString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid timestamps value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	limitBytesString := queries.Get("limitBytes")
+	var limitBytes int64 = -1
+	if limitBytesString != "" {
+		var err error
+		limitBytes, err = strconv.ParseInt(limitBytesString, 10, 64)
+		if err != nil || limitBytes < 1 {
+			errorMessage := fmt.Sprintf("Invalid limitBytes value: %s\n", limitBytesString)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
 			helper.LogError.Printf(errorMessage)
 			return
 		}
 	}
 
+	//Which container to read logs from, needed for multi-container pods
+	container := queries.Get("container")
+
+	//Return log records instead of plain text
+	jsonOutput := strings.Contains(r.Header.Get("Accept"), "application/json")
+
 	//Get the deployment
 	dep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
 
-	selector := dep.Spec.Selector
-	label, err := labels.Parse("component=" + selector.MatchLabels["component"])
+	label, err := componentSelector(dep)
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error parsing label selector: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 		helper.LogError.Printf(errorMessage)
 		return
 	}
@@ -1014,40 +1830,115 @@ func getDeploymentLogs(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		errorMessage := fmt.Sprintf("Error retrieving pods: %s\n", err)
-		http.Error(w, errorMessage, http.StatusInternalServerError)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 		helper.LogError.Printf(errorMessage)
 		return
 	}
 
-	logBuffer := bytes.NewBuffer(nil)
+	podLogOpts := api.PodLogOptions{}
 
-	for _, pod := range pods.Items {
-		podLogOpts := &api.PodLogOptions{}
+	if tail != -1 {
+		podLogOpts.TailLines = &tail
+	}
+
+	if previous {
+		podLogOpts.Previous = previous
+	}
+
+	if sinceSeconds != -1 {
+		podLogOpts.SinceSeconds = &sinceSeconds
+	}
+
+	if sinceTime != nil {
+		podLogOpts.SinceTime = sinceTime
+	}
+
+	if limitBytes != -1 {
+		podLogOpts.LimitBytes = &limitBytes
+	}
+
+	podLogOpts.Timestamps = timestamps
+	podLogOpts.Container = container
+
+	if follow {
+		streamDeploymentLogs(w, r, podInterface, label, pods, podLogOpts, jsonOutput)
+		helper.LogInfo.Printf("Finished following Logs for Deployment: %v\n", dep.GetName())
+		return
+	}
+
+	if jsonOutput {
+		//Timestamps are needed to fill in each record
+		podLogOpts.Timestamps = true
+
+		records := []logRecord{}
+		for _, pod := range pods.Items {
+			stream, err := podInterface.GetLogs(pod.Name, &podLogOpts).Stream()
+			if err != nil {
+				errorMessage := fmt.Sprintf("Error getting log stream: %s\n", err)
+				helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+				helper.LogError.Printf(errorMessage)
+				return
+			}
+
+			containerName := container
+			if containerName == "" && len(pod.Spec.Containers) != 0 {
+				containerName = pod.Spec.Containers[0].Name
+			}
 
-		if tail != -1 {
-			podLogOpts.TailLines = &tail
+			scanner := bufio.NewScanner(stream)
+			scanner.Buffer(make([]byte, 64*1024), maxLogLineLength)
+			for scanner.Scan() {
+				timestamp, line := splitLogTimestamp(scanner.Text())
+				records = append(records, logRecord{
+					Pod:       pod.Name,
+					Container: containerName,
+					Timestamp: timestamp,
+					Line:      line,
+				})
+			}
+			stream.Close()
+			if err := scanner.Err(); err != nil {
+				errorMessage := fmt.Sprintf("Error reading log stream: %s\n", err)
+				helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+				helper.LogError.Printf(errorMessage)
+				return
+			}
 		}
 
-		if previous {
-			podLogOpts.Previous = previous
+		js, err := json.Marshal(records)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error marshalling log records: %v\n", err)
+			helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+			helper.LogError.Printf(errorMessage)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write(js)
+
+		helper.LogInfo.Printf("Got Logs for Deployment: %v\n", dep.GetName())
+		return
+	}
+
+	logBuffer := bytes.NewBuffer(nil)
 
-		req := podInterface.GetLogs(pod.Name, podLogOpts)
+	for _, pod := range pods.Items {
+		req := podInterface.GetLogs(pod.Name, &podLogOpts)
 		stream, err := req.Stream()
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error getting log stream: %s\n", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
 			helper.LogError.Printf(errorMessage)
 			return
 		}
 
-		defer stream.Close()
 		podLogLine := fmt.Sprintf("Logs for pod: %v\n", pod.Name)
 		_, err = logBuffer.WriteString(podLogLine)
 		_, err = io.Copy(logBuffer, stream)
+		stream.Close()
 		if err != nil {
 			errorMessage := fmt.Sprintf("Error copying log stream to var: %s\n", err)
-			http.Error(w, errorMessage, http.StatusInternalServerError)
+			helper.WriteError(w, errorMessage, http.StatusInternalServerError)
 			helper.LogError.Printf(errorMessage)
 			return
 		}
@@ -1060,60 +1951,453 @@ func getDeploymentLogs(w http.ResponseWriter, r *http.Request) {
 	helper.LogInfo.Printf("Got Logs for Deployment: %v\n", dep.GetName())
 }
 
-func getStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte("OK"))
+//splitLogTimestamp splits the RFC3339 timestamp kubernetes prefixes to each line off of the line itself
+func splitLogTimestamp(line string) (string, string) {
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) != 2 {
+		return "", line
+	}
+	if _, err := time.Parse(time.RFC3339Nano, parts[0]); err != nil {
+		return "", line
+	}
+	return parts[0], parts[1]
 }
 
-func isCPSEnabledForOrg(orgName, authzHeader string) bool {
-	cpsEnabled := false
-	httpClient := &http.Client{}
+//componentSelector selects the replica sets and pods of a deployment by its component label
+func componentSelector(dep *extensions.Deployment) (labels.Selector, error) {
+	return labels.Parse("component=" + dep.Spec.Selector.MatchLabels["component"])
+}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/v1/organizations/%s", apigeeApiHost, orgName), nil)
+//streamDeploymentLogs follows the logs of every pod in pods and multiplexes them into a single chunked response.
+//Pods that come up while the stream is open are picked up through a watch on the same label selector.
+//With jsonOutput each line is a logRecord on a line of its own, otherwise lines are prefixed with their pod.
+func streamDeploymentLogs(w http.ResponseWriter, r *http.Request, podInterface kubeclient.PodInterface, label labels.Selector, pods *api.PodList, podLogOpts api.PodLogOptions, jsonOutput bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorMessage := "Streaming unsupported\n"
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
 
+	watcher, err := podInterface.Watch(api.ListOptions{
+		LabelSelector:   label,
+		ResourceVersion: pods.ResourceVersion,
+	})
 	if err != nil {
-		fmt.Printf("Error checking for CPS: %v", err)
+		errorMessage := fmt.Sprintf("Error watching pods: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	defer watcher.Stop()
+
+	//Cancelled when the client goes away, on shutdown or when we stop streaming for any other reason
+	ctx, cancel := streams.track(r.Context())
 
-		return cpsEnabled
+	var wg sync.WaitGroup
+	lines := make(chan string)
+	followed := make(map[string]bool)
+	//A pod's stream ends when its container stops, it's followed again from then on once the pod changes
+	ended := make(chan string)
+	resumeFrom := make(map[string]unversioned.Time)
+
+	//Make sure every per-pod reader has exited before we return
+	defer wg.Wait()
+	defer cancel()
+
+	podLogOpts.Follow = true
+	podLogOpts.Previous = false
+	if jsonOutput {
+		//Timestamps are needed to fill in each record
+		podLogOpts.Timestamps = true
+	}
+
+	//formatLine turns a line of a pod's log into what's written to the response
+	formatLine := func(pod *api.Pod, text string) string {
+		if !jsonOutput {
+			return fmt.Sprintf("[%s] %s\n", pod.Name, text)
+		}
+		containerName := podLogOpts.Container
+		if containerName == "" && len(pod.Spec.Containers) != 0 {
+			containerName = pod.Spec.Containers[0].Name
+		}
+		timestamp, line := splitLogTimestamp(text)
+		js, err := json.Marshal(logRecord{
+			Pod:       pod.Name,
+			Container: containerName,
+			Timestamp: timestamp,
+			Line:      line,
+		})
+		if err != nil {
+			helper.LogWarn.Printf("Error marshalling log record for pod %s: %s\n", pod.Name, err)
+			return ""
+		}
+		return string(js) + "\n"
 	}
 
-	fmt.Printf("Checking if %s has CPS enabled using URL: %v\n", orgName, req.URL.String())
+	followPod := func(pod *api.Pod) {
+		if followed[pod.Name] || pod.Status.Phase != api.PodRunning {
+			return
+		}
 
-	req.Header.Add("Authorization", authzHeader)
+		opts := podLogOpts
+		if since, ok := resumeFrom[pod.Name]; ok {
+			//Only what the restarted container logged since the last stream ended
+			opts.SinceSeconds = nil
+			opts.TailLines = nil
+			opts.SinceTime = &since
+		}
 
-	res, err := httpClient.Do(req)
+		stream, err := podInterface.GetLogs(pod.Name, &opts).Stream()
+		if err != nil {
+			helper.LogWarn.Printf("Error getting log stream for pod %s: %s\n", pod.Name, err)
+			return
+		}
+		followed[pod.Name] = true
 
-	defer res.Body.Close()
+		wg.Add(2)
 
-	if err != nil {
-		fmt.Printf("Error checking for CPS: %v", err)
+		//Closed when the pod's stream ends so its closer doesn't wait for the whole request
+		done := make(chan struct{})
+		var closeOnce sync.Once
+		closeStream := func() {
+			closeOnce.Do(func() { stream.Close() })
+		}
+
+		//Closing the stream is the only way to unblock a pending read
+		go func() {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+			case <-done:
+			}
+			closeStream()
+		}()
+
+		go func(pod *api.Pod) {
+			defer wg.Done()
+			defer close(done)
+			defer closeStream()
+			defer func() {
+				select {
+				case ended <- pod.Name:
+				case <-ctx.Done():
+				}
+			}()
+			scanner := bufio.NewScanner(stream)
+			scanner.Buffer(make([]byte, 64*1024), maxLogLineLength)
+			for scanner.Scan() {
+				select {
+				case lines <- formatLine(pod, scanner.Text()):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err := scanner.Err(); err != nil && ctx.Err() == nil {
+				helper.LogWarn.Printf("Error reading log stream for pod %s: %s\n", pod.Name, err)
+			}
+		}(pod)
+	}
+
+	if jsonOutput {
+		w.Header().Set("Content-Type", "application/x-ndjson")
 	} else {
-		var rawOrg interface{}
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(200)
+	flusher.Flush()
 
-		err := json.NewDecoder(res.Body).Decode(&rawOrg)
+	for i := range pods.Items {
+		followPod(&pods.Items[i])
+	}
 
+	for {
+		select {
+		case line := <-lines:
+			if _, err := io.WriteString(w, line); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				helper.LogWarn.Printf("Pod watch closed while following logs\n")
+				return
+			}
+			pod, isPod := event.Object.(*api.Pod)
+			if !isPod {
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				followPod(pod)
+			case watch.Deleted:
+				delete(followed, pod.Name)
+				delete(resumeFrom, pod.Name)
+			}
+		case name := <-ended:
+			delete(followed, name)
+			resumeFrom[name] = unversioned.Now()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//getDeploymentRevisions returns the retained revision history of a deployment
+func getDeploymentRevisions(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	//Get the deployment
+	dep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error retrieving deployment: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	rsList, err := getDeploymentReplicaSets(dep)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting replica set list: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	revisions := []deploymentRevision{}
+	for _, rs := range rsList {
+		revision, err := deploymentutil.Revision(&rs)
 		if err != nil {
-			fmt.Printf("Error unmarshalling response: %v\n", err)
-		} else {
-			org := rawOrg.(map[string]interface{})
-			orgProps := org["properties"].(map[string]interface{})
-			orgProp := orgProps["property"].([]interface{})
+			helper.LogWarn.Printf("Skipping replica set %s with invalid revision: %v\n", rs.GetName(), err)
+			continue
+		}
 
-			for _, rawProp := range orgProp {
-				prop := rawProp.(map[string]interface{})
+		var images []string
+		for _, container := range rs.Spec.Template.Spec.Containers {
+			images = append(images, container.Image)
+		}
 
-				if prop["name"] == "features.isCpsEnabled" {
-					if prop["value"] == "true" {
-						cpsEnabled = true
-					}
+		revisions = append(revisions, deploymentRevision{
+			Revision:    revision,
+			Images:      images,
+			CreatedAt:   rs.CreationTimestamp,
+			ChangeCause: rs.Annotations[changeCauseAnnotation],
+		})
+	}
 
-					break
-				}
+	//Newest revision first
+	sort.Sort(sort.Reverse(byRevision(revisions)))
+
+	js, err := json.Marshal(revisions)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling revision list: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Got Revisions for Deployment: %v\n", dep.GetName())
+}
+
+//rollbackDeployment restores the PodTemplateSpec of a previous revision onto a deployment
+func rollbackDeployment(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	//Decode passed JSON body
+	var tempJSON deploymentRollback
+	err := json.NewDecoder(r.Body).Decode(&tempJSON)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error decoding JSON Body: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusBadRequest)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	getDep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Get(pathVars["deployment"])
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting existing deployment: %s\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	rsList, err := getDeploymentReplicaSets(getDep)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error getting replica set list: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	currentRevision, _ := strconv.ParseInt(getDep.Annotations[deploymentutil.RevisionAnnotation], 10, 64)
+
+	//A revision of 0 means the newest revision older than the current one
+	var targetRS *extensions.ReplicaSet
+	var targetRevision int64
+	for i := range rsList {
+		revision, err := deploymentutil.Revision(&rsList[i])
+		if err != nil {
+			continue
+		}
+		if tempJSON.Revision == 0 {
+			if revision < currentRevision && revision > targetRevision {
+				targetRS = &rsList[i]
+				targetRevision = revision
 			}
+		} else if revision == tempJSON.Revision {
+			targetRS = &rsList[i]
+			targetRevision = revision
+			break
 		}
 	}
 
-	fmt.Printf("  CPS Enabled: %v", cpsEnabled)
+	if targetRS == nil {
+		errorMessage := fmt.Sprintf("Revision %d not found for deployment %s\n", tempJSON.Revision, getDep.GetName())
+		helper.WriteError(w, errorMessage, http.StatusNotFound)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
 
-	return cpsEnabled
+	if targetRevision == currentRevision {
+		errorMessage := fmt.Sprintf("Deployment %s is already at revision %d\n", getDep.GetName(), targetRevision)
+		helper.WriteError(w, errorMessage, http.StatusConflict)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	//Need to cache the current annotations
+	cacheAnnotations := getDep.Spec.Template.Annotations
+
+	deploymentutil.SetFromReplicaSetTemplate(getDep, targetRS.Spec.Template)
+
+	//If annotations map is empty then we need to make it
+	if len(getDep.Spec.Template.Annotations) == 0 {
+		getDep.Spec.Template.Annotations = make(map[string]string)
+	}
+
+	//If labels map is empty then we need to make it
+	if len(getDep.Spec.Template.Labels) == 0 {
+		getDep.Spec.Template.Labels = make(map[string]string)
+	}
+
+	//Replace the privateHosts and publicHosts annotations with cached ones
+	getDep.Spec.Template.Annotations["publicHosts"] = cacheAnnotations["publicHosts"]
+	getDep.Spec.Template.Annotations["privateHosts"] = cacheAnnotations["privateHosts"]
+
+	//Add routable label
+	getDep.Spec.Template.Labels["routable"] = "true"
+
+	//An old revision may predate the current policy
+	err = applyPolicies(pathVars["org"]+"-"+pathVars["env"], &getDep.Spec.Template.Spec)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error rolling back deployment: %v\n", err)
+		status, details := policyErrorStatus(err)
+		helper.WriteError(w, errorMessage, status, details...)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	if len(getDep.Annotations) == 0 {
+		getDep.Annotations = make(map[string]string)
+	}
+	getDep.Annotations[changeCauseAnnotation] = fmt.Sprintf("rollback to revision %d", targetRevision)
+
+	dep, err := client.Deployments(pathVars["org"] + "-" + pathVars["env"]).Update(getDep)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error rolling back deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+
+	js, err := json.Marshal(dep)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling deployment: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+	helper.LogInfo.Printf("Rolled back Deployment %s to revision %d\n", dep.GetName(), targetRevision)
+}
+
+//getDeploymentReplicaSets lists the replica sets that the given deployment's selector targets
+func getDeploymentReplicaSets(dep *extensions.Deployment) ([]extensions.ReplicaSet, error) {
+	return deploymentutil.ListReplicaSets(dep, func(namespace string, options api.ListOptions) ([]extensions.ReplicaSet, error) {
+		rsList, err := client.ReplicaSets(namespace).List(options)
+		if err != nil {
+			return nil, err
+		}
+		return rsList.Items, nil
+	})
+}
+
+//getStatus is the liveness check, it doesn't touch any dependency so a slow one can't get enrober restarted
+func getStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("OK"))
+}
+
+//countManaged counts the environments enrober created and the deployments in them, for the metrics gauges
+func countManaged() (int, int, error) {
+	selector, err := labels.Parse("Runtime=shipyard")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	nsList, err := client.Namespaces().List(api.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	//One list across every namespace is cheaper than one per environment
+	depList, err := client.Deployments(api.NamespaceAll).List(api.ListOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	environments := map[string]bool{}
+	for _, ns := range nsList.Items {
+		environments[ns.Name] = true
+	}
+
+	deployments := 0
+	for _, dep := range depList.Items {
+		if environments[dep.Namespace] {
+			deployments++
+		}
+	}
+	return len(nsList.Items), deployments, nil
+}
+
+//routingKVM is the KVM holding an environment's public routing key
+func routingKVM(publicKey []byte) apigee.KVM {
+	return apigee.KVM{
+		Name: apigeeKVMName,
+		Entry: []apigee.KVMEntry{
+			apigee.KVMEntry{
+				Name:  apigeeKVMPKName,
+				Value: base64.StdEncoding.EncodeToString(publicKey),
+			},
+		},
+	}
 }
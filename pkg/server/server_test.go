@@ -1,16 +1,29 @@
 package server_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
-	"os"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/30x/enrober/pkg/apigee"
+	"github.com/30x/enrober/pkg/config"
+	"github.com/30x/enrober/pkg/kubeclient"
+	"github.com/30x/enrober/pkg/kubeclient/fake"
 	"github.com/30x/enrober/pkg/server"
 
-	"k8s.io/kubernetes/pkg/client/restclient"
+	"k8s.io/kubernetes/pkg/api"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -29,7 +42,7 @@ type environmentResponse struct {
 }
 
 var _ = Describe("Server Test", func() {
-	ServerTests := func(testServer *server.Server, hostBase string) {
+	ServerTests := func(kubeClient *fake.Client, hostBase string, ptsBase string) {
 
 		client := &http.Client{}
 
@@ -73,7 +86,35 @@ var _ = Describe("Server Test", func() {
 
 			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
 
-			Expect(resp.StatusCode).Should(Equal(500), "Response should be 500 Internal Server Error")
+			respStore := errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Code).Should(Equal("Conflict"))
+			Expect(respStore.RequestID).Should(Equal(resp.Header.Get("X-Request-Id")))
+			Expect(respStore.RequestID).ShouldNot(BeEmpty())
+
+			Expect(resp.StatusCode).Should(Equal(409), "Response should be 409 Conflict")
+		})
+
+		It("Create Environment with invalid JSON", func() {
+			url := fmt.Sprintf("%s/environments", hostBase)
+
+			req, err := http.NewRequest("POST", url, bytes.NewBufferString(`{"environmentName": `))
+			req.Header.Set("X-Request-Id", "test-request")
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+
+			respStore := errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//The caller's request id is kept
+			Expect(respStore.RequestID).Should(Equal("test-request"))
+			Expect(respStore.Code).Should(Equal("BadRequest"))
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
 		})
 
 		It("Update Environment", func() {
@@ -99,6 +140,71 @@ var _ = Describe("Server Test", func() {
 			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
 		})
 
+		It("Rotate Environment Keys", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/keys/rotate", hostBase)
+
+			jsonStr := []byte(`{"gracePeriodSeconds": 60}`)
+			req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+
+			respStore := environmentResponse{}
+
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//Both keys should have been replaced
+			Expect(string(respStore.PrivateSecret)).ShouldNot(Equal(globalPrivate))
+			Expect(string(respStore.PublicSecret)).ShouldNot(Equal(globalPublic))
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			//The original keys are still in their grace period so they can't be replaced
+			req, err = http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(409), "Response should be 409 Conflict")
+
+			secret, err := kubeClient.Secrets("testorg1-testenv1").Get("routing")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+			Expect(string(secret.Data["public-api-key-previous"])).Should(Equal(globalPublic))
+		})
+
+		It("Get KVM Status before sync", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/kvm/status", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := kvmStatus{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//APIGEE_KVM is off so nothing created the KVM
+			Expect(respStore.KVMExists).Should(BeFalse())
+			Expect(respStore.InSync).Should(BeFalse())
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Sync KVM", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/kvm/sync", hostBase)
+
+			resp, err := client.Post(url, "application/json", nil)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+
+			respStore := kvmStatus{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.KVMExists).Should(BeTrue())
+			Expect(respStore.InSync).Should(BeTrue())
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
 		It("Create Deployment from PTS URL", func() {
 			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments", hostBase)
 
@@ -107,7 +213,7 @@ var _ = Describe("Server Test", func() {
 				"publicHosts": "deploy.k8s.public",
 				"privateHosts": "deploy.k8s.private",
     			"replicas": 1,
-    			"ptsURL": "https://api.myjson.com/bins/2p9z1",
+    			"ptsURL": "` + ptsBase + `/pts/testpod1",
 				"envVars": [{
 					"name": "test1",
 					"value": "test3"
@@ -129,14 +235,11 @@ var _ = Describe("Server Test", func() {
 		})
 
 		It("Update Deployment from PTS URL", func() {
-			//Need to wait a little before we run an update
-			//Should look into a better fix
-			time.Sleep(2000 * time.Millisecond)
 			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1", hostBase)
 
 			jsonStr := []byte(`{
 				"replicas": 3,
-				"ptsURL": "https://api.myjson.com/bins/119h9",
+				"ptsURL": "` + ptsBase + `/pts/testpod1-v2",
 				"envVars": [{
 					"name": "test1",
 					"value": "test3"
@@ -215,8 +318,6 @@ var _ = Describe("Server Test", func() {
 		})
 
 		It("Update Deployment from direct PTS", func() {
-			time.Sleep(2000 * time.Millisecond)
-
 			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
 
 			jsonStr := []byte(`{
@@ -298,6 +399,145 @@ var _ = Describe("Server Test", func() {
 
 		})
 
+		It("Get Status for Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/status", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := deploymentStatus{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Rollout).Should(Equal("finished"))
+			Expect(respStore.DesiredReplicas).Should(Equal(int32(3)))
+			Expect(respStore.AvailableReplicas).Should(Equal(int32(3)))
+			Expect(respStore.Pods).Should(HaveLen(3))
+			for _, pod := range respStore.Pods {
+				Expect(pod.Phase).Should(Equal("Running"))
+				Expect(pod.Ready).Should(BeTrue())
+				Expect(pod.Node).ShouldNot(BeEmpty())
+			}
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Wait for the rollout of Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/status?wait=true&timeout=10s", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := deploymentStatus{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//The fake rolls out straight away
+			Expect(respStore.Rollout).Should(Equal("finished"))
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Wait for a rollout with an invalid timeout", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/status?wait=true&timeout=1h", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+		})
+
+		It("Wait for a stalled rollout of Deployment testdep2", func() {
+			kubeClient.StallContainers("testorg1-testenv1", "testdep2", "ImagePullBackOff")
+
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2/status?wait=true&timeout=10s", hostBase)
+
+			start := time.Now()
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := deploymentStatus{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//Waiting won't pull the image, so there's no point running out the timeout
+			Expect(time.Since(start)).Should(BeNumerically("<", 2*time.Second))
+			Expect(respStore.Rollout).Should(Equal("stalled"))
+			Expect(respStore.Pods).ShouldNot(BeEmpty())
+			for _, pod := range respStore.Pods {
+				Expect(pod.WaitingReason).Should(Equal("ImagePullBackOff"))
+			}
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Get Deployments", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments", hostBase)
+
+			req, err := http.NewRequest("GET", url, nil)
+
+			resp, err := client.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Get Deployments a page at a time", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments?view=summary&limit=1", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := deploymentSummaryList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Items).Should(HaveLen(1))
+			Expect(respStore.Items[0].DeploymentName).Should(Equal("testdep1"))
+			Expect(respStore.Items[0].PublicHosts).Should(Equal("deploy.k8s.public"))
+			Expect(respStore.Metadata.Continue).ShouldNot(BeEmpty())
+
+			resp, err = client.Get(url + "&continue=" + respStore.Metadata.Continue)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore = deploymentSummaryList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//The last page has no continue token
+			Expect(respStore.Items).Should(HaveLen(1))
+			Expect(respStore.Items[0].DeploymentName).Should(Equal("testdep2"))
+			Expect(respStore.Metadata.Continue).Should(BeEmpty())
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Get Deployments by field", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments?view=summary&fieldSelector=metadata.name%%3Dtestdep2", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := deploymentSummaryList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Items).Should(HaveLen(1))
+			Expect(respStore.Items[0].DeploymentName).Should(Equal("testdep2"))
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Get Deployments with an invalid sort", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments?sort=size", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+		})
+
 		It("Get Environment", func() {
 			url := fmt.Sprintf("%s/environments/testorg1:testenv1", hostBase)
 
@@ -310,11 +550,63 @@ var _ = Describe("Server Test", func() {
 			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
 		})
 
+		It("List Environments", func() {
+			url := fmt.Sprintf("%s/environments?org=testorg1", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := environmentList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Items).Should(HaveLen(1))
+			Expect(respStore.Items[0].Name).Should(Equal("testorg1-testenv1"))
+			Expect(respStore.Items[0].HostNames).Should(Equal([]string{"testhost2"}))
+			Expect(respStore.Items[0].Deployments).Should(Equal(2))
+
+			//Secrets are left out unless asked for
+			Expect(respStore.Items[0].PrivateSecret).Should(BeNil())
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("List Environments with Secrets", func() {
+			url := fmt.Sprintf("%s/environments?includeSecrets=true", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := environmentList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Items).Should(HaveLen(1))
+			Expect(respStore.Items[0].PrivateSecret).ShouldNot(BeNil())
+			Expect(respStore.Items[0].PublicSecret).ShouldNot(BeNil())
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("List Environments of an unknown org", func() {
+			url := fmt.Sprintf("%s/environments?org=testorg3", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := environmentList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Items).Should(BeEmpty())
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
 		It("Get Logs for Deployment testdep1", func() {
-			//Need to wait for container to start
-			time.Sleep(5000 * time.Millisecond)
+			kubeClient.SetPodLogs("testorg1-testenv1", "testdep1", "2016-08-01T00:00:00.000000000Z line one\n2016-08-01T00:00:01.000000000Z line two\n")
 
-			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/logs", hostBase)
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/logs?tail=1", hostBase)
 
 			req, err := http.NewRequest("GET", url, nil)
 
@@ -323,83 +615,1715 @@ var _ = Describe("Server Test", func() {
 			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
 
 			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(resp.Body)
+			Expect(buf.String()).Should(ContainSubstring("line two"))
+			Expect(buf.String()).ShouldNot(ContainSubstring("line one"))
 		})
 
-		It("Delete Deployment testdep1", func() {
-			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1", hostBase)
+		It("Get JSON Logs for Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/logs", hostBase)
 
-			req, err := http.NewRequest("DELETE", url, nil)
+			req, err := http.NewRequest("GET", url, nil)
+			req.Header.Set("Accept", "application/json")
 
 			resp, err := client.Do(req)
 
-			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
 
-			Expect(resp.StatusCode).Should(Equal(204), "Response should be 200 OK")
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
 
+			var records []logRecord
+			err = json.NewDecoder(resp.Body).Decode(&records)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(records).ShouldNot(BeEmpty())
+			Expect(records[0].Container).Should(Equal("test"))
+			Expect(records[0].Timestamp).Should(Equal("2016-08-01T00:00:00.000000000Z"))
+			Expect(records[0].Line).Should(Equal("line one"))
 		})
 
-		It("Delete Deployment testdep2", func() {
-			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
+		It("Follow Logs for Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/logs?follow=true", hostBase)
 
-			req, err := http.NewRequest("DELETE", url, nil)
+			req, err := http.NewRequest("GET", url, nil)
 
-			resp, err := client.Do(req)
+			//The stream never ends on its own
+			followClient := &http.Client{Timeout: 5 * time.Second}
+			resp, err := followClient.Do(req)
 
-			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			defer resp.Body.Close()
 
-			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
 
+			//Every line should be prefixed with the pod it came from
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			Expect(err).Should(BeNil(), "Error reading stream: %v", err)
+			Expect(line).Should(HavePrefix("[testdep1-"))
 		})
 
-		It("Delete Environment", func() {
-			url := fmt.Sprintf("%s/environments/testorg1:testenv1", hostBase)
+		It("Follow Logs with long lines until the pod streams end", func() {
+			longLine := strings.Repeat("x", 100*1024)
+			kubeClient.SetPodLogs("testorg1-testenv1", "testdep1", "line one\n"+longLine+"\n")
 
-			req, err := http.NewRequest("DELETE", url, nil)
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/logs?follow=true", hostBase)
 
-			resp, err := client.Do(req)
+			req, err := http.NewRequest("GET", url, nil)
 
-			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			followClient := &http.Client{Timeout: 5 * time.Second}
+			resp, err := followClient.Do(req)
 
-			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			//Lines over the scanner's default 64KB come through whole
+			reader := bufio.NewReader(resp.Body)
+			for {
+				line, err := reader.ReadString('\n')
+				Expect(err).Should(BeNil(), "Error reading stream: %v", err)
+				if strings.HasSuffix(line, "] "+longLine+"\n") {
+					break
+				}
+			}
+			go io.Copy(ioutil.Discard, reader)
+
+			//The pods' streams have ended, they're closed while the request is still open
+			Eventually(kubeClient.OpenLogStreams, 2*time.Second).Should(BeZero())
 		})
-	}
 
-	Context("Local Testing", func() {
-		server, hostBase, err := setup()
-		if err != nil {
-			Fail(fmt.Sprintf("Failed to start server %s", err))
-		}
+		It("Follow Logs of a restarted container", func() {
+			kubeClient.SetPodLogs("testorg1-testenv1", "testdep1", "before the crash\n")
 
-		ServerTests(server, hostBase)
-	})
-})
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/logs?follow=true", hostBase)
 
-//Initialize a server for testing
-func setup() (*server.Server, string, error) {
-	kubeHost := os.Getenv("KUBE_HOST")
-	testServer := server.NewServer()
+			req, err := http.NewRequest("GET", url, nil)
 
-	if kubeHost == "" {
-		kubeHost = "127.0.0.1:8080"
-	}
-	clientConfig := restclient.Config{
-		Host: kubeHost,
-	}
-	err := server.Init(clientConfig)
-	if err != nil {
-		fmt.Printf("Error on init: %v\n", err)
-	}
+			followClient := &http.Client{Timeout: 5 * time.Second}
+			resp, err := followClient.Do(req)
 
-	//Start in background
-	go func() {
-		err := testServer.Start()
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			defer resp.Body.Close()
 
-		if err != nil {
-			fmt.Printf("Could not start server %s", err)
-		}
-	}()
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			reader := bufio.NewReader(resp.Body)
+			line, err := reader.ReadString('\n')
+			Expect(err).Should(BeNil(), "Error reading stream: %v", err)
+			Expect(line).Should(HaveSuffix("] before the crash\n"))
+
+			Eventually(kubeClient.OpenLogStreams, 2*time.Second).Should(BeZero())
+
+			//The same pod is followed again once its container restarts
+			kubeClient.SetPodLogs("testorg1-testenv1", "testdep1", "after the restart\n")
+			kubeClient.RestartContainers("testorg1-testenv1", "testdep1")
+
+			for {
+				line, err = reader.ReadString('\n')
+				Expect(err).Should(BeNil(), "Error reading stream: %v", err)
+				if strings.HasSuffix(line, "] after the restart\n") {
+					break
+				}
+			}
+		})
+
+		It("Follow Logs as JSON records", func() {
+			kubeClient.SetPodLogs("testorg1-testenv1", "testdep1", "2016-08-01T00:00:00.000000000Z line one\n")
+
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/logs?follow=true", hostBase)
+
+			req, err := http.NewRequest("GET", url, nil)
+			req.Header.Set("Accept", "application/json")
+
+			followClient := &http.Client{Timeout: 5 * time.Second}
+			resp, err := followClient.Do(req)
 
-	hostBase := "http://localhost:9000"
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+			Expect(resp.Header.Get("Content-Type")).Should(Equal("application/x-ndjson"))
+
+			//Each line is a record of its own
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			Expect(err).Should(BeNil(), "Error reading stream: %v", err)
+
+			record := logRecord{}
+			err = json.Unmarshal([]byte(line), &record)
+			Expect(err).Should(BeNil(), "Error decoding record: %v", err)
+			Expect(record.Pod).Should(HavePrefix("testdep1-"))
+			Expect(record.Container).Should(Equal("test"))
+			Expect(record.Timestamp).Should(Equal("2016-08-01T00:00:00.000000000Z"))
+			Expect(record.Line).Should(Equal("line one"))
+		})
+
+		It("Get Events for Environment", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/events", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := eventList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			reasons := map[string]bool{}
+			for _, event := range respStore.Items {
+				reasons[event.Reason] = true
+			}
+			Expect(reasons).Should(HaveKey("ScalingReplicaSet"))
+			Expect(reasons).Should(HaveKey("SuccessfulCreate"))
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Get Events for Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2/events?since=1h", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := eventList{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//Only events about testdep2 and the objects it owns
+			Expect(respStore.Items).ShouldNot(BeEmpty())
+			for _, event := range respStore.Items {
+				Expect(event.InvolvedObject.Name).Should(HavePrefix("testdep2"))
+			}
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
 
-	return testServer, hostBase, nil
+		It("Watch Events for Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/events?watch=true&since=2030-01-01T00:00:00Z", hostBase)
+
+			req, err := http.NewRequest("GET", url, nil)
+
+			//The stream never ends on its own
+			watchClient := &http.Client{Timeout: 5 * time.Second}
+			resp, err := watchClient.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+			Expect(resp.Header.Get("Content-Type")).Should(Equal("text/event-stream"))
+
+			podList, err := kubeClient.Pods("testorg1-testenv1").List(api.ListOptions{})
+			Expect(err).Should(BeNil(), "Shouldn't get an error listing pods. Error: %v", err)
+			var podName string
+			for _, pod := range podList.Items {
+				if strings.HasPrefix(pod.Name, "testdep1-") {
+					podName = pod.Name
+				}
+			}
+
+			kubeClient.AddEvent(&api.Event{
+				ObjectMeta: api.ObjectMeta{
+					Name:      podName + ".failed",
+					Namespace: "testorg1-testenv1",
+				},
+				InvolvedObject: api.ObjectReference{
+					Kind: "Pod",
+					Name: podName,
+				},
+				Type:    api.EventTypeWarning,
+				Reason:  "FailedScheduling",
+				Message: "No nodes are available",
+			})
+
+			//Everything older than since was left out so the first event is the new one
+			reader := bufio.NewReader(resp.Body)
+			line, err := reader.ReadString('\n')
+			Expect(err).Should(BeNil(), "Error reading stream: %v", err)
+			Expect(line).Should(Equal("event: Warning\n"))
+
+			line, err = reader.ReadString('\n')
+			Expect(err).Should(BeNil(), "Error reading stream: %v", err)
+			Expect(line).Should(ContainSubstring(`"reason":"FailedScheduling"`))
+		})
+
+		It("Scale Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/scale", hostBase)
+
+			req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"replicas": 2}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+
+			respStore := scale{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Spec.Replicas).Should(Equal(int32(2)))
+			Expect(respStore.Status.Replicas).Should(Equal(int32(2)))
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Scale Deployment testdep1 above the environment maximum", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/scale", hostBase)
+
+			//Operators bound the replicas with namespace annotations
+			ns, err := kubeClient.Namespaces().Get("testorg1-testenv1")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the namespace. Error: %v", err)
+			ns.Annotations["maxReplicas"] = "4"
+			_, err = kubeClient.Namespaces().Update(ns)
+			Expect(err).Should(BeNil(), "Shouldn't get an error updating the namespace. Error: %v", err)
+
+			req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"replicas": 5}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+
+			respStore := errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Message).Should(ContainSubstring("at most 4"))
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+
+			//The PATCH route is held to the same bounds
+			url = fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1", hostBase)
+			req, err = http.NewRequest("PATCH", url, bytes.NewBufferString(`{"replicas": 5, "ptsURL": "`+ptsBase+`/pts/testpod1-v2"}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+		})
+
+		It("Set Autoscaler for Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/autoscaler", hostBase)
+
+			jsonStr := []byte(`{"minReplicas": 2, "maxReplicas": 4, "targetCPUUtilizationPercentage": 70}`)
+			req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+
+			respStore := autoscaler{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.MaxReplicas).Should(Equal(int32(4)))
+			Expect(respStore.CurrentReplicas).Should(Equal(int32(2)))
+
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+		})
+
+		It("Set Autoscaler above the environment maximum", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/autoscaler", hostBase)
+
+			jsonStr := []byte(`{"minReplicas": 2, "maxReplicas": 6}`)
+			req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+		})
+
+		It("Get Deployment testdep1 with its Autoscaler", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := struct {
+				Metadata struct {
+					Name string `json:"name"`
+				} `json:"metadata"`
+				Autoscaler *autoscaler `json:"autoscaler"`
+			}{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Metadata.Name).Should(Equal("testdep1"))
+			Expect(respStore.Autoscaler).ShouldNot(BeNil())
+			Expect(respStore.Autoscaler.MinReplicas).Should(Equal(int32(2)))
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Delete Autoscaler for Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/autoscaler", hostBase)
+
+			req, err := http.NewRequest("DELETE", url, nil)
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(404), "Response should be 404 Not Found")
+		})
+
+		It("Get Revisions for Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/revisions", hostBase)
+
+			req, err := http.NewRequest("GET", url, nil)
+
+			resp, err := client.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Rollback Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1/rollback", hostBase)
+
+			jsonStr := []byte(`{"revision": 0}`)
+			req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Get missing Deployment", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/nosuchdep", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Code).Should(Equal("NotFound"))
+
+			Expect(resp.StatusCode).Should(Equal(404), "Response should be 404 Not Found")
+		})
+
+		It("Create Deployment with the environment's default resources", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments", hostBase)
+
+			//Operators set the resource policy with namespace annotations
+			ns, err := kubeClient.Namespaces().Get("testorg1-testenv1")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the namespace. Error: %v", err)
+			ns.Annotations["defaultCPURequest"] = "100m"
+			ns.Annotations["defaultMemoryLimit"] = "256Mi"
+			ns.Annotations["maxContainerCPU"] = "1"
+			ns.Annotations["maxContainerMemory"] = "512Mi"
+			_, err = kubeClient.Namespaces().Update(ns)
+			Expect(err).Should(BeNil(), "Shouldn't get an error updating the namespace. Error: %v", err)
+
+			jsonStr := []byte(`{
+				"deploymentName": "testdep3",
+				"publicHosts": "deploy.k8s.public",
+				"replicas": 1,
+				"ptsURL": "` + ptsBase + `/pts/testpod3"
+			}`)
+
+			req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			respStore := struct {
+				Spec struct {
+					Template struct {
+						Spec struct {
+							Containers []struct {
+								Resources struct {
+									Limits   map[string]string `json:"limits"`
+									Requests map[string]string `json:"requests"`
+								} `json:"resources"`
+							} `json:"containers"`
+						} `json:"spec"`
+					} `json:"template"`
+				} `json:"spec"`
+			}{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			resources := respStore.Spec.Template.Spec.Containers[0].Resources
+			Expect(resources.Requests["cpu"]).Should(Equal("100m"))
+			Expect(resources.Limits["memory"]).Should(Equal("256Mi"))
+			//Without a default limit the maximum is the limit
+			Expect(resources.Limits["cpu"]).Should(Equal("1"))
+
+			req, err = http.NewRequest("DELETE", url+"/testdep3", nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+		})
+
+		It("Create Deployment over the environment's maximum resources", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments", hostBase)
+
+			jsonStr := []byte(`{
+				"deploymentName": "testdep4",
+				"publicHosts": "deploy.k8s.public",
+				"replicas": 1,
+				"pts": {
+					"metadata": {
+						"labels": {
+							"component": "web4"
+						}
+					},
+					"spec": {
+						"containers": [{
+							"name": "test",
+							"image": "jbowen/testapp:v0",
+							"resources": {
+								"limits": {
+									"cpu": "2",
+									"memory": "1Gi"
+								}
+							}
+						}]
+					}
+				}
+			}`)
+
+			req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+
+			respStore := errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Details).Should(HaveLen(2))
+			Expect(respStore.Details[0]).Should(ContainSubstring("the maximum is 1"))
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+
+			//PATCHes are held to the same policy
+			url = fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1", hostBase)
+			req, err = http.NewRequest("PATCH", url, bytes.NewBufferString(`{"pts": {
+				"metadata": {"labels": {"component": "web1"}},
+				"spec": {"containers": [{"name": "test", "image": "jbowen/testapp:v0", "resources": {"limits": {"memory": "1Gi"}}}]}
+			}}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+
+			//A request over the maximum is refused even without a limit, which would be the maximum
+			req, err = http.NewRequest("PATCH", url, bytes.NewBufferString(`{"pts": {
+				"metadata": {"labels": {"component": "web1"}},
+				"spec": {"containers": [{"name": "test", "image": "jbowen/testapp:v0", "resources": {"requests": {"cpu": "2"}}}]}
+			}}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+
+			respStore = errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Details).Should(ConsistOf(ContainSubstring("container test requests cpu of 2, over its limit of 1")))
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+		})
+
+		It("Create privileged Deployment", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments", hostBase)
+
+			jsonStr := []byte(`{
+				"deploymentName": "testdep5",
+				"publicHosts": "deploy.k8s.public",
+				"replicas": 1,
+				"pts": {
+					"metadata": {
+						"labels": {
+							"component": "web5"
+						}
+					},
+					"spec": {
+						"containers": [{
+							"name": "test",
+							"image": "jbowen/testapp:v0",
+							"securityContext": {
+								"privileged": true
+							}
+						}]
+					}
+				}
+			}`)
+
+			req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			//Privileged containers aren't allowed so the container is changed
+			dep, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep5")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+			Expect(*dep.Spec.Template.Spec.Containers[0].SecurityContext.Privileged).Should(BeFalse())
+		})
+
+		It("Update Deployment with host access", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep5", hostBase)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"pts": {
+				"metadata": {"labels": {"component": "web5"}},
+				"spec": {
+					"securityContext": {"hostNetwork": true},
+					"containers": [{"name": "test", "image": "jbowen/testapp:v0", "securityContext": {"privileged": true}}]
+				}
+			}}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+
+			respStore := errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Details).Should(Equal([]string{"pod uses the host network"}))
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+		})
+
+		It("Update Deployment against the environment's security policy", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep5", hostBase)
+
+			ns, err := kubeClient.Namespaces().Get("testorg1-testenv1")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the namespace. Error: %v", err)
+			ns.Annotations["allowedRegistries"] = "gcr.io docker.io/library"
+			ns.Annotations["capabilitiesPolicy"] = "override"
+			ns.Annotations["runAsNonRootPolicy"] = "reject"
+			_, err = kubeClient.Namespaces().Update(ns)
+			Expect(err).Should(BeNil(), "Shouldn't get an error updating the namespace. Error: %v", err)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"pts": {
+				"metadata": {"labels": {"component": "web5"}},
+				"spec": {"containers": [
+					{"name": "test", "image": "jbowen/testapp:v0", "securityContext": {"capabilities": {"add": ["NET_ADMIN"]}}},
+					{"name": "proxy", "image": "nginx", "securityContext": {"runAsUser": 1000}}
+				]}
+			}}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+
+			//Capabilities are overridden rather than rejected
+			respStore := errorResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Details).Should(HaveLen(2))
+			Expect(respStore.Details[0]).Should(ContainSubstring("container test may run as root"))
+			Expect(respStore.Details[1]).Should(ContainSubstring("container test image jbowen/testapp:v0 isn't from an allowed registry"))
+
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+
+			ns, err = kubeClient.Namespaces().Get("testorg1-testenv1")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the namespace. Error: %v", err)
+			for _, annotation := range []string{"allowedRegistries", "capabilitiesPolicy", "runAsNonRootPolicy"} {
+				delete(ns.Annotations, annotation)
+			}
+			_, err = kubeClient.Namespaces().Update(ns)
+			Expect(err).Should(BeNil(), "Shouldn't get an error updating the namespace. Error: %v", err)
+
+			req, err = http.NewRequest("DELETE", url, nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+		})
+
+		It("Dry run creating a Deployment", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments?dryRun=true", hostBase)
+
+			jsonStr := []byte(`{
+				"deploymentName": "testdep6",
+				"publicHosts": "deploy.k8s.public",
+				"replicas": 1,
+				"ptsURL": "` + ptsBase + `/pts/testpod3"
+			}`)
+
+			req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			respStore := struct {
+				Metadata struct {
+					Name string `json:"name"`
+				} `json:"metadata"`
+				Spec struct {
+					Template struct {
+						Metadata struct {
+							Labels      map[string]string `json:"labels"`
+							Annotations map[string]string `json:"annotations"`
+						} `json:"metadata"`
+					} `json:"template"`
+				} `json:"spec"`
+			}{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Metadata.Name).Should(Equal("testdep6"))
+			Expect(respStore.Spec.Template.Metadata.Labels["routable"]).Should(Equal("true"))
+			Expect(respStore.Spec.Template.Metadata.Annotations["publicHosts"]).Should(Equal("deploy.k8s.public"))
+
+			//Nothing was created
+			_, err = kubeClient.Deployments("testorg1-testenv1").Get("testdep6")
+			Expect(err).ShouldNot(BeNil(), "The deployment shouldn't exist")
+
+			req, err = http.NewRequest("POST", fmt.Sprintf("%s/environments/testorg1:testenv1/deployments?dryRun=maybe", hostBase), bytes.NewBuffer(jsonStr))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+		})
+
+		It("Dry run updating Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2?dryRun=true", hostBase)
+
+			before, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"publicHosts": "dryrun.k8s.local"}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			respStore := struct {
+				Deployment struct {
+					Metadata struct {
+						Name string `json:"name"`
+					} `json:"metadata"`
+				} `json:"deployment"`
+				Diff []struct {
+					Path string      `json:"path"`
+					Op   string      `json:"op"`
+					Old  interface{} `json:"old"`
+					New  interface{} `json:"new"`
+				} `json:"diff"`
+			}{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Deployment.Metadata.Name).Should(Equal("testdep2"))
+
+			var hostChanged bool
+			for _, change := range respStore.Diff {
+				if change.Path == "spec.template.metadata.annotations.publicHosts" {
+					hostChanged = true
+					Expect(change.Op).Should(Equal("replace"))
+					Expect(change.Old).Should(Equal("deploy.k8s.local"))
+					Expect(change.New).Should(Equal("dryrun.k8s.local"))
+				}
+			}
+			Expect(hostChanged).Should(BeTrue(), "The diff should have the publicHosts change: %v", respStore.Diff)
+
+			//Nothing was updated
+			after, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+			Expect(after.ResourceVersion).Should(Equal(before.ResourceVersion))
+		})
+
+		It("Set a secret Env Var for Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2/env/DB_PASSWORD", hostBase)
+
+			req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"value": "hunter2", "secret": true}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			respStore := envVar{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Container).Should(Equal("test"))
+			Expect(respStore.Secret).Should(BeTrue())
+			Expect(respStore.Value).Should(BeNil())
+
+			//The value is only in the secret
+			secret, err := kubeClient.Secrets("testorg1-testenv1").Get("testdep2-env")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+			Expect(string(secret.Data["test.DB_PASSWORD"])).Should(Equal("hunter2"))
+
+			for _, url := range []string{url, fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)} {
+				resp, err = client.Get(url)
+				Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+				Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).Should(BeNil(), "Error reading response: %v", err)
+				Expect(string(body)).ShouldNot(ContainSubstring("hunter2"))
+			}
+
+			dep, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+			Expect(dep.Spec.Template.Annotations["envSecretChecksum"]).ShouldNot(BeEmpty())
+		})
+
+		It("Keep the secret of Deployment testdep2 when its Env Var can't be set", func() {
+			//A maximum under the deployment's limit makes every update fail
+			ns, err := kubeClient.Namespaces().Get("testorg1-testenv1")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the namespace. Error: %v", err)
+			ns.Annotations["maxContainerCPU"] = "500m"
+			_, err = kubeClient.Namespaces().Update(ns)
+			Expect(err).Should(BeNil(), "Shouldn't get an error updating the namespace. Error: %v", err)
+
+			for _, name := range []string{"DB_PASSWORD", "API_TOKEN"} {
+				url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2/env/%s", hostBase, name)
+
+				req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"value": "letmein", "secret": true}`))
+
+				resp, err := client.Do(req)
+				Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+				Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+			}
+
+			//The old value is back and the new one is gone
+			secret, err := kubeClient.Secrets("testorg1-testenv1").Get("testdep2-env")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+			Expect(string(secret.Data["test.DB_PASSWORD"])).Should(Equal("hunter2"))
+			Expect(secret.Data).ShouldNot(HaveKey("test.API_TOKEN"))
+
+			ns, err = kubeClient.Namespaces().Get("testorg1-testenv1")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the namespace. Error: %v", err)
+			ns.Annotations["maxContainerCPU"] = "1"
+			_, err = kubeClient.Namespaces().Update(ns)
+			Expect(err).Should(BeNil(), "Shouldn't get an error updating the namespace. Error: %v", err)
+		})
+
+		It("Set a plain Env Var for a container of Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2/env/LOG_LEVEL?container=test", hostBase)
+
+			req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"value": "debug"}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			respStore := envVar{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(*respStore.Value).Should(Equal("debug"))
+
+			url = fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2/env/LOG_LEVEL?container=missing", hostBase)
+			req, err = http.NewRequest("PUT", url, bytes.NewBufferString(`{"value": "debug"}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(404), "Response should be 404 Not Found")
+		})
+
+		It("Keep secret Env Vars when the PTS of Deployment testdep2 is replaced", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"pts": {
+				"metadata": {"labels": {"component": "web2"}},
+				"spec": {"containers": [{"name": "test", "image": "jbowen/testapp:v1"}]}
+			}}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			dep, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+
+			var names []string
+			for _, env := range dep.Spec.Template.Spec.Containers[0].Env {
+				names = append(names, env.Name)
+			}
+			Expect(names).Should(Equal([]string{"DB_PASSWORD"}))
+		})
+
+		It("Delete Env Vars of Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2/env/DB_PASSWORD", hostBase)
+
+			req, err := http.NewRequest("DELETE", url, nil)
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+
+			//It was the last secret value so the secret is gone
+			_, err = kubeClient.Secrets("testorg1-testenv1").Get("testdep2-env")
+			Expect(err).ShouldNot(BeNil(), "The secret shouldn't exist")
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(404), "Response should be 404 Not Found")
+		})
+
+		It("Set Config app-settings", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/configs/app-settings", hostBase)
+
+			req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"data": {"app.properties": "color=blue\n"}}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			respStore := configResource{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Data).Should(Equal(map[string]string{"app.properties": "color=blue\n"}))
+			Expect(respStore.Deployments).Should(BeEmpty())
+
+			req, err = http.NewRequest("PUT", url, bytes.NewBufferString(`{"data": {"../app.properties": "color=blue\n"}}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+
+			resp, err = client.Get(fmt.Sprintf("%s/environments/testorg1:testenv1/configs", hostBase))
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			listStore := struct {
+				Items []configResource `json:"items"`
+			}{}
+			err = json.NewDecoder(resp.Body).Decode(&listStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(listStore.Items).Should(HaveLen(1))
+			Expect(listStore.Items[0].Name).Should(Equal("app-settings"))
+		})
+
+		It("Mount Config app-settings in Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"configs": [{"name": "missing", "mountPath": "/etc/missing"}]}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+
+			req, err = http.NewRequest("PATCH", url, bytes.NewBufferString(`{"configs": [{"name": "app-settings", "mountPath": "/etc/app"}]}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			//Replacing the PTS without configs keeps the mount
+			req, err = http.NewRequest("PATCH", url, bytes.NewBufferString(`{"pts": {
+				"metadata": {"labels": {"component": "web2"}},
+				"spec": {"containers": [{"name": "test", "image": "jbowen/testapp:v1"}]}
+			}}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			dep, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+
+			spec := dep.Spec.Template.Spec
+			Expect(spec.Volumes).Should(HaveLen(1))
+			Expect(spec.Volumes[0].Name).Should(Equal("config-app-settings"))
+			Expect(spec.Volumes[0].ConfigMap.Name).Should(Equal("app-settings"))
+			Expect(spec.Containers[0].VolumeMounts).Should(Equal([]api.VolumeMount{{Name: "config-app-settings", MountPath: "/etc/app", ReadOnly: true}}))
+			Expect(dep.Spec.Template.Annotations["configChecksum"]).ShouldNot(BeEmpty())
+		})
+
+		It("Update Config app-settings mounted by Deployment testdep2", func() {
+			dep, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+			checksum := dep.Spec.Template.Annotations["configChecksum"]
+
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/configs/app-settings", hostBase)
+
+			req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"data": {"app.properties": "color=green\n"}}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			respStore := configResource{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Deployments).Should(Equal([]string{"testdep2"}))
+
+			//The new checksum rolls the pods
+			dep, err = kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+			Expect(dep.Spec.Template.Annotations["configChecksum"]).ShouldNot(Equal(checksum))
+
+			req, err = http.NewRequest("DELETE", url, nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(409), "Response should be 409 Conflict")
+		})
+
+		It("Unmount and Delete Config app-settings", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"configs": []}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			dep, err := kubeClient.Deployments("testorg1-testenv1").Get("testdep2")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the deployment. Error: %v", err)
+			Expect(dep.Spec.Template.Spec.Volumes).Should(BeEmpty())
+			Expect(dep.Spec.Template.Spec.Containers[0].VolumeMounts).Should(BeEmpty())
+			Expect(dep.Spec.Template.Annotations).ShouldNot(HaveKey("configChecksum"))
+
+			url = fmt.Sprintf("%s/environments/testorg1:testenv1/configs/app-settings", hostBase)
+
+			req, err = http.NewRequest("DELETE", url, nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(404), "Response should be 404 Not Found")
+		})
+
+		It("Set Secret db-credentials", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/secrets/db-credentials", hostBase)
+
+			req, err := http.NewRequest("PUT", url, bytes.NewBufferString(`{"data": {"username": "app", "password": "hunter2"}}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).Should(BeNil(), "Error reading response: %v", err)
+			Expect(string(body)).ShouldNot(ContainSubstring("hunter2"))
+
+			respStore := secretResource{}
+			err = json.Unmarshal(body, &respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Keys).Should(Equal([]string{"password", "username"}))
+			Expect(respStore.ResourceVersion).ShouldNot(BeEmpty())
+
+			secret, err := kubeClient.Secrets("testorg1-testenv1").Get("db-credentials")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+			Expect(string(secret.Data["password"])).Should(Equal("hunter2"))
+
+			//enrober's secrets and those created some other way can't be set
+			_, err = kubeClient.Secrets("testorg1-testenv1").Create(&api.Secret{
+				ObjectMeta: api.ObjectMeta{Name: "registry"},
+				Data:       map[string][]byte{".dockercfg": []byte("{}")},
+				Type:       api.SecretTypeDockercfg,
+			})
+			Expect(err).Should(BeNil(), "Shouldn't get an error creating the secret. Error: %v", err)
+
+			for name, status := range map[string]int{"routing": 400, "testdep2-env": 400, "image-pull": 400, "registry": 409} {
+				url := fmt.Sprintf("%s/environments/testorg1:testenv1/secrets/%s", hostBase, name)
+				req, err = http.NewRequest("PUT", url, bytes.NewBufferString(`{"data": {"key": "value"}}`))
+
+				resp, err = client.Do(req)
+				Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+				Expect(resp.StatusCode).Should(Equal(status), "Response for %s should be %d", name, status)
+
+				resp, err = client.Get(url)
+				Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+				Expect(resp.StatusCode).Should(Equal(404), "Response for %s should be 404 Not Found", name)
+			}
+
+			resp, err = client.Get(fmt.Sprintf("%s/environments/testorg1:testenv1/secrets", hostBase))
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			listStore := struct {
+				Items []secretResource `json:"items"`
+			}{}
+			err = json.NewDecoder(resp.Body).Decode(&listStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(listStore.Items).Should(HaveLen(1))
+			Expect(listStore.Items[0].Name).Should(Equal("db-credentials"))
+		})
+
+		It("Use Secret db-credentials in Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"envVars": [
+				{"name": "DB_USER", "valueFrom": {"secretKeyRef": {"name": "db-credentials", "key": "username"}}}
+			]}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			url = fmt.Sprintf("%s/environments/testorg1:testenv1/secrets/db-credentials", hostBase)
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			respStore := secretResource{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Deployments).Should(Equal([]string{"testdep2"}))
+			version := respStore.ResourceVersion
+
+			req, err = http.NewRequest("PUT", url, bytes.NewBufferString(`{"data": {"username": "app", "password": "correct-horse"}}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PUT. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.ResourceVersion).ShouldNot(Equal(version))
+
+			req, err = http.NewRequest("DELETE", url, nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(409), "Response should be 409 Conflict")
+		})
+
+		It("Delete Secret db-credentials", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
+
+			req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(`{"pts": {
+				"metadata": {"labels": {"component": "web2"}},
+				"spec": {"containers": [{"name": "test", "image": "jbowen/testapp:v1"}]}
+			}}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on PATCH. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			url = fmt.Sprintf("%s/environments/testorg1:testenv1/secrets/db-credentials", hostBase)
+
+			req, err = http.NewRequest("DELETE", url, nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+
+			//The routing secret is never the API's to delete
+			req, err = http.NewRequest("DELETE", fmt.Sprintf("%s/environments/testorg1:testenv1/secrets/routing", hostBase), nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(404), "Response should be 404 Not Found")
+
+			_, err = kubeClient.Secrets("testorg1-testenv1").Get("routing")
+			Expect(err).Should(BeNil(), "The routing secret should still exist")
+		})
+
+		It("Delete Deployment testdep1", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep1", hostBase)
+
+			req, err := http.NewRequest("DELETE", url, nil)
+
+			resp, err := client.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 200 OK")
+
+		})
+
+		It("Delete Deployment testdep2", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/deployments/testdep2", hostBase)
+
+			req, err := http.NewRequest("DELETE", url, nil)
+
+			resp, err := client.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+
+		})
+
+		It("Get Status", func() {
+			url := fmt.Sprintf("%s/environments/status", hostBase)
+
+			req, err := http.NewRequest("GET", url, nil)
+
+			resp, err := client.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Get Readiness", func() {
+			url := fmt.Sprintf("%s/environments/status/ready", hostBase)
+
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := readiness{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			//APIGEE_KVM is off so only kubernetes is checked
+			Expect(respStore.Ready).Should(BeTrue())
+			Expect(respStore.Dependencies).Should(HaveLen(2))
+
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			//The passing access check is reused by the next probe
+			accessChecks := kubeClient.AccessChecks()
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+			Expect(kubeClient.AccessChecks()).Should(Equal(accessChecks))
+		})
+
+		It("Get Readiness without access to pods", func() {
+			url := fmt.Sprintf("%s/environments/status/ready", hostBase)
+
+			check := kubeclient.AccessCheck{Verb: "delete", Resource: "pods"}
+			kubeClient.SetAllowed(check, false)
+			defer kubeClient.SetAllowed(check, true)
+
+			//The access check that passed is still cached
+			resp, err := client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			server.SetReadinessCache(time.Minute)
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+
+			respStore := readiness{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			Expect(respStore.Ready).Should(BeFalse())
+			Expect(respStore.Dependencies[1].Ready).Should(BeFalse())
+			Expect(respStore.Dependencies[1].Message).Should(ContainSubstring("delete pods"))
+
+			Expect(resp.StatusCode).Should(Equal(503), "Response should be 503 Service Unavailable")
+
+			//Failures aren't cached so the pod is ready as soon as access is given back
+			kubeClient.SetAllowed(check, true)
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+		})
+
+		It("Expire previous routing keys after a restart", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1/keys/rotate", hostBase)
+
+			//End the grace period of the earlier rotation so the keys can be rotated again
+			secret, err := kubeClient.Secrets("testorg1-testenv1").Get("routing")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+			secret.Annotations["previousKeysExpire"] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+			_, err = kubeClient.Secrets("testorg1-testenv1").Update(secret)
+			Expect(err).Should(BeNil(), "Shouldn't get an error updating the secret. Error: %v", err)
+
+			req, err := http.NewRequest("POST", url, bytes.NewBufferString(`{"gracePeriodSeconds": 0}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			//The timer set on rotation expires them straight away
+			Eventually(func() map[string][]byte {
+				secret, err := kubeClient.Secrets("testorg1-testenv1").Get("routing")
+				Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+				return secret.Data
+			}).ShouldNot(HaveKey("public-api-key-previous"))
+
+			//Put the previous keys back as if the process that rotated them stopped before its timer fired
+			Eventually(func() error {
+				secret, err := kubeClient.Secrets("testorg1-testenv1").Get("routing")
+				if err != nil {
+					return err
+				}
+				secret.Data["public-api-key-previous"] = []byte("old-public")
+				secret.Data["private-api-key-previous"] = []byte("old-private")
+				secret.Annotations["previousKeysExpire"] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+				_, err = kubeClient.Secrets("testorg1-testenv1").Update(secret)
+				return err
+			}).Should(Succeed())
+
+			//Starting a server sweeps the expired keys
+			cfg := config.Default()
+			cfg.Port = freePort()
+			cfg.AdminPort = freePort()
+			startServer := server.NewServer(cfg)
+			server.SetApigeeClient(apigee.NewClient(apigeeURL))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- startServer.Start(ctx)
+			}()
+
+			Eventually(func() map[string][]byte {
+				secret, err := kubeClient.Secrets("testorg1-testenv1").Get("routing")
+				Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+				return secret.Data
+			}).ShouldNot(Or(HaveKey("public-api-key-previous"), HaveKey("private-api-key-previous")))
+
+			secret, err = kubeClient.Secrets("testorg1-testenv1").Get("routing")
+			Expect(err).Should(BeNil(), "Shouldn't get an error getting the secret. Error: %v", err)
+			Expect(secret.Annotations).ShouldNot(HaveKey("previousKeysExpire"))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("Rotate Environment Keys without a KVM", func() {
+			req, err := http.NewRequest("POST", fmt.Sprintf("%s/environments", hostBase), bytes.NewBufferString(`{"environmentName": "testorg1:nokvm", "hostNames": ["nokvmhost"]}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			//The environment was created before APIGEE_KVM was turned on
+			server.SetApigeeKVM(true)
+			defer server.SetApigeeKVM(false)
+
+			req, err = http.NewRequest("POST", fmt.Sprintf("%s/environments/testorg1:nokvm/keys/rotate", hostBase), bytes.NewBufferString(`{}`))
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			respStore := environmentResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+
+			resp, err = client.Get(fmt.Sprintf("%s/v1/organizations/testorg1/environments/nokvm/keyvaluemaps/routing", apigeeURL))
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "The KVM should have been created")
+
+			kvm := apigee.KVM{}
+			err = json.NewDecoder(resp.Body).Decode(&kvm)
+			Expect(err).Should(BeNil(), "Error decoding KVM: %v", err)
+			Expect(kvm.Entry).Should(HaveLen(1))
+			Expect(kvm.Entry[0].Value).Should(Equal(base64.StdEncoding.EncodeToString(respStore.PublicSecret)))
+
+			req, err = http.NewRequest("DELETE", fmt.Sprintf("%s/environments/testorg1:nokvm", hostBase), nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+		})
+
+		It("Delete Environment keeping its KVM", func() {
+			server.SetApigeeKVM(true)
+			defer server.SetApigeeKVM(false)
+
+			req, err := http.NewRequest("POST", fmt.Sprintf("%s/environments", hostBase), bytes.NewBufferString(`{"environmentName": "testorg1:keepkvm", "hostNames": ["keepkvmhost"]}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			req, err = http.NewRequest("DELETE", fmt.Sprintf("%s/environments/testorg1:keepkvm?keepKVM=true", hostBase), nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+
+			_, err = kubeClient.Namespaces().Get("testorg1-keepkvm")
+			Expect(err).ShouldNot(BeNil(), "The namespace should be gone")
+
+			resp, err = client.Get(fmt.Sprintf("%s/v1/organizations/testorg1/environments/keepkvm/keyvaluemaps/routing", apigeeURL))
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "The KVM should still exist")
+		})
+
+		It("Delete Environment that doesn't exist", func() {
+			server.SetApigeeKVM(true)
+			defer server.SetApigeeKVM(false)
+
+			requests := atomic.LoadInt64(&apigeeRequests)
+
+			req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/environments/testorg1:missing", hostBase), nil)
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(404), "Response should be 404 Not Found")
+
+			req, err = http.NewRequest("DELETE", fmt.Sprintf("%s/environments/testorg1:testenv1?keepKVM=yes", hostBase), nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(400), "Response should be 400 Bad Request")
+
+			//Neither KVM was touched
+			Expect(atomic.LoadInt64(&apigeeRequests)).Should(Equal(requests))
+		})
+
+		It("Delete Environment when its KVM can't be removed", func() {
+			server.SetApigeeKVM(true)
+			defer server.SetApigeeKVM(false)
+
+			req, err := http.NewRequest("POST", fmt.Sprintf("%s/environments", hostBase), bytes.NewBufferString(`{"environmentName": "testorg1:kvmfail", "hostNames": ["kvmfailhost"]}`))
+
+			resp, err := client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on POST. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(201), "Response should be 201 Created")
+
+			url := fmt.Sprintf("%s/environments/testorg1:kvmfail", hostBase)
+
+			req, err = http.NewRequest("DELETE", url, nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(502), "Response should be 502 Bad Gateway")
+
+			respStore := struct {
+				Deleted []string          `json:"deleted"`
+				Failed  map[string]string `json:"failed"`
+			}{}
+			err = json.NewDecoder(resp.Body).Decode(&respStore)
+			Expect(err).Should(BeNil(), "Error decoding response: %v", err)
+			Expect(respStore.Deleted).Should(BeEmpty())
+			Expect(respStore.Failed).Should(HaveKey("kvm"))
+
+			//Nothing was deleted so the environment is still there to retry
+			_, err = kubeClient.Namespaces().Get("testorg1-kvmfail")
+			Expect(err).Should(BeNil(), "The namespace should still exist")
+
+			resp, err = client.Get(url)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			req, err = http.NewRequest("DELETE", url+"?keepKVM=true", nil)
+
+			resp, err = client.Do(req)
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+		})
+
+		It("Delete Environment", func() {
+			url := fmt.Sprintf("%s/environments/testorg1:testenv1", hostBase)
+
+			req, err := http.NewRequest("DELETE", url, nil)
+
+			resp, err := client.Do(req)
+
+			Expect(err).Should(BeNil(), "Shouldn't get an error on DELETE. Error: %v", err)
+
+			Expect(resp.StatusCode).Should(Equal(204), "Response should be 204 No Content")
+		})
+
+		It("Start and Shut Down", func() {
+			cfg := config.Default()
+			cfg.Port = freePort()
+			cfg.AdminPort = freePort()
+			startServer := server.NewServer(cfg)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- startServer.Start(ctx)
+			}()
+
+			//Health checks are on the admin listener
+			Eventually(func() error {
+				resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/status", cfg.AdminPort))
+				if err == nil {
+					resp.Body.Close()
+				}
+				return err
+			}).Should(Succeed())
+
+			//So are the metrics, which should have counted the earlier requests
+			resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", cfg.AdminPort))
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			Expect(err).Should(BeNil(), "Shouldn't get an error reading metrics. Error: %v", err)
+			Expect(string(body)).Should(ContainSubstring(`enrober_http_requests_total{code="201",method="POST",route="/environments"}`))
+			Expect(string(body)).Should(ContainSubstring("enrober_apigee_requests_total"))
+			Expect(string(body)).Should(ContainSubstring("enrober_pts_fetch_duration_seconds"))
+
+			//A watch only ends with the client, shutdown ends it instead of waiting on it
+			_, err = kubeClient.Namespaces().Create(&api.Namespace{ObjectMeta: api.ObjectMeta{Name: "testorg1-shutdown"}})
+			Expect(err).Should(BeNil(), "Shouldn't get an error creating the namespace. Error: %v", err)
+
+			resp, err = client.Get(fmt.Sprintf("http://127.0.0.1:%d/environments/testorg1:shutdown/events?watch=true", cfg.Port))
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			watchDone := make(chan error)
+			go func() {
+				_, err := ioutil.ReadAll(resp.Body)
+				watchDone <- err
+			}()
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+			Eventually(watchDone).Should(Receive(BeNil()))
+		})
+
+		It("Check access to everything the server used", func() {
+			//Readiness should notice enrober losing any action it takes, the earlier specs took all of them
+			server.SetReadinessCache(0)
+
+			resp, err := client.Get(fmt.Sprintf("%s/environments/status/ready", hostBase))
+			Expect(err).Should(BeNil(), "Shouldn't get an error on GET. Error: %v", err)
+			Expect(resp.StatusCode).Should(Equal(200), "Response should be 200 OK")
+
+			Expect(kubeClient.UncheckedAccess()).Should(BeEmpty(), "requiredAccess in ready.go is missing actions")
+		})
+	}
+
+	Context("Local Testing", func() {
+		kubeClient, hostBase, ptsBase, err := setup()
+		if err != nil {
+			Fail(fmt.Sprintf("Failed to start server %s", err))
+		}
+
+		ServerTests(kubeClient, hostBase, ptsBase)
+	})
+})
+
+type errorResponse struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Details   []string `json:"details"`
+	RequestID string   `json:"requestId"`
+}
+
+type kvmStatus struct {
+	Name      string `json:"name"`
+	KVMExists bool   `json:"kvmExists"`
+	InSync    bool   `json:"inSync"`
+}
+
+type readiness struct {
+	Ready        bool `json:"ready"`
+	Dependencies []struct {
+		Name    string `json:"name"`
+		Ready   bool   `json:"ready"`
+		Message string `json:"message"`
+	} `json:"dependencies"`
+}
+
+type deploymentSummaryList struct {
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []struct {
+		DeploymentName string `json:"deploymentName"`
+		PublicHosts    string `json:"publicHosts"`
+		Replicas       int32  `json:"replicas"`
+	} `json:"items"`
+}
+
+type environmentList struct {
+	Items []struct {
+		Name          string   `json:"name"`
+		HostNames     []string `json:"hostNames"`
+		Deployments   int      `json:"deployments"`
+		PublicSecret  []byte   `json:"publicSecret"`
+		PrivateSecret []byte   `json:"privateSecret"`
+	} `json:"items"`
+}
+
+type deploymentStatus struct {
+	Rollout           string `json:"rollout"`
+	DesiredReplicas   int32  `json:"desiredReplicas"`
+	AvailableReplicas int32  `json:"availableReplicas"`
+	Pods              []struct {
+		Name          string `json:"name"`
+		Phase         string `json:"phase"`
+		Ready         bool   `json:"ready"`
+		Node          string `json:"node"`
+		WaitingReason string `json:"waitingReason"`
+	} `json:"pods"`
+}
+
+type eventList struct {
+	Items []struct {
+		Type           string `json:"type"`
+		Reason         string `json:"reason"`
+		InvolvedObject struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"involvedObject"`
+	} `json:"items"`
+}
+
+type scale struct {
+	Spec struct {
+		Replicas int32 `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		Replicas int32 `json:"replicas"`
+	} `json:"status"`
+}
+
+type autoscaler struct {
+	MinReplicas     int32 `json:"minReplicas"`
+	MaxReplicas     int32 `json:"maxReplicas"`
+	CurrentReplicas int32 `json:"currentReplicas"`
+}
+
+type envVar struct {
+	Name      string  `json:"name"`
+	Container string  `json:"container"`
+	Value     *string `json:"value"`
+	Secret    bool    `json:"secret"`
+}
+
+type configResource struct {
+	Name        string            `json:"name"`
+	Data        map[string]string `json:"data"`
+	Deployments []string          `json:"deployments"`
+}
+
+type secretResource struct {
+	Name            string   `json:"name"`
+	Keys            []string `json:"keys"`
+	ResourceVersion string   `json:"resourceVersion"`
+	Deployments     []string `json:"deployments"`
+}
+
+type logRecord struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Timestamp string `json:"timestamp"`
+	Line      string `json:"line"`
+}
+
+//Pod template specs served to the ptsURL tests
+var testPTS = map[string]string{
+	"testpod1":    testPodJSON("web1", 80),
+	"testpod1-v2": testPodJSON("web1", 81),
+	"testpod3":    testPodJSON("web3", 82),
+}
+
+func testPodJSON(component string, port int) string {
+	return fmt.Sprintf(`{
+		"metadata": {
+			"labels": {
+				"component": "%s"
+			},
+			"annotations": {
+				"publicPaths": "%d:/",
+				"privatePaths": "%d:/"
+			}
+		},
+		"spec": {
+			"containers": [{
+				"name": "test",
+				"image": "jbowen/testapp:v0",
+				"env": [{
+					"name": "PORT",
+					"value": "%d"
+				}],
+				"ports": [{
+					"containerPort": %d
+				}]
+			}]
+		}
+	}`, component, port, port, port, port)
+}
+
+//Initialize a server for testing backed by a fake kubernetes client
+func setup() (*fake.Client, string, string, error) {
+	kubeClient := fake.NewClient()
+
+	err := server.InitWithClient(kubeClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	cfg := config.Default()
+	cfg.ImagePullSecret = "image-pull"
+	testServer := server.NewServer(cfg)
+	apigeeServer := fakeApigee()
+	apigeeURL = apigeeServer.URL
+	server.SetApigeeClient(apigee.NewClient(apigeeURL))
+	enrober := httptest.NewServer(testServer.Router)
+
+	pts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := testPTS[strings.TrimPrefix(r.URL.Path, "/pts/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+
+	return kubeClient, enrober.URL, pts.URL, nil
+}
+
+//apigeeURL is where the fake Apigee management API is served
+var apigeeURL string
+
+//apigeeRequests counts the calls made to the fake Apigee
+var apigeeRequests int64
+
+//fakeApigee serves the KVM endpoints of the management API from memory, for an org without CPS.
+//Deleting the KVMs of the kvmfail environment fails.
+func fakeApigee() *httptest.Server {
+	var lock sync.Mutex
+	kvms := map[string]apigee.KVM{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		atomic.AddInt64(&apigeeRequests, 1)
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case len(parts) == 3 && r.Method == "GET":
+			w.Write([]byte(`{"properties": {"property": []}}`))
+		case len(parts) == 6 && r.Method == "POST":
+			kvm := apigee.KVM{}
+			json.NewDecoder(r.Body).Decode(&kvm)
+			key := strings.Join(append(parts, kvm.Name), "/")
+			if _, ok := kvms[key]; ok {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			kvms[key] = kvm
+			w.WriteHeader(http.StatusCreated)
+		case len(parts) == 7 && r.Method == "GET":
+			kvm, ok := kvms[r.URL.Path[1:]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(kvm)
+		case len(parts) == 7 && r.Method == "POST":
+			if _, ok := kvms[r.URL.Path[1:]]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			kvm := apigee.KVM{}
+			json.NewDecoder(r.Body).Decode(&kvm)
+			kvms[r.URL.Path[1:]] = kvm
+		case len(parts) == 7 && r.Method == "DELETE" && parts[4] == "kvmfail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case len(parts) == 7 && r.Method == "DELETE":
+			delete(kvms, r.URL.Path[1:])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+//freePort finds a port nothing is listening on
+func freePort() int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		Fail(fmt.Sprintf("Failed to find a free port: %v", err))
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
 }
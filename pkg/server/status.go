@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+const (
+	//Rollout states of a deploymentStatus
+	rolloutFinished    = "finished"
+	rolloutProgressing = "progressing"
+	rolloutStalled     = "stalled"
+
+	defaultWaitTimeout = time.Minute
+	maxWaitTimeout     = 10 * time.Minute
+
+	//How often a waiting status request looks at the deployment again
+	waitPollInterval = 2 * time.Second
+)
+
+//stalledReasons are container waiting reasons that won't fix themselves without a new template
+var stalledReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"ErrImageNeverPull":          true,
+	"RunContainerError":          true,
+	"CreateContainerConfigError": true,
+}
+
+//getDeploymentStatus reports how far the rollout of a deployment has got and the health of its pods.
+//With wait=true it doesn't reply until the rollout finishes or stalls, or 504 once timeout runs out.
+func getDeploymentStatus(w http.ResponseWriter, r *http.Request) {
+	pathVars := mux.Vars(r)
+
+	if checkOrgAdmin {
+		if !helper.ValidAdmin(pathVars["org"], w, r) {
+			return
+		}
+	}
+
+	queries := r.URL.Query()
+
+	waitString := queries.Get("wait")
+	var wait bool
+	if waitString != "" {
+		var err error
+		wait, err = strconv.ParseBool(waitString)
+		if err != nil {
+			errorMessage := fmt.Sprintf("Invalid wait value: %s\n", err)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	timeout := defaultWaitTimeout
+	timeoutString := queries.Get("timeout")
+	if timeoutString != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutString)
+		if err != nil || timeout <= 0 || timeout > maxWaitTimeout {
+			errorMessage := fmt.Sprintf("Invalid timeout value: %s, must be a duration up to %v\n", timeoutString, maxWaitTimeout)
+			helper.WriteError(w, errorMessage, http.StatusBadRequest)
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+	}
+
+	namespace := pathVars["org"] + "-" + pathVars["env"]
+	deadline := time.After(timeout)
+
+	for {
+		status, err := deploymentRolloutStatus(namespace, pathVars["deployment"])
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error getting deployment status: %v\n", err)
+			helper.WriteError(w, errorMessage, kubeErrorStatus(err))
+			helper.LogError.Printf(errorMessage)
+			return
+		}
+
+		//A stalled rollout won't finish by waiting longer
+		if !wait || status.Rollout != rolloutProgressing {
+			writeDeploymentStatus(w, status)
+			return
+		}
+
+		select {
+		case <-time.After(waitPollInterval):
+		case <-deadline:
+			errorMessage := fmt.Sprintf("Rollout of %s didn't finish within %v\n", status.Name, timeout)
+			helper.WriteError(w, errorMessage, http.StatusGatewayTimeout,
+				fmt.Sprintf("rollout: %s", status.Rollout),
+				fmt.Sprintf("%d of %d replicas updated, %d available", status.UpdatedReplicas, status.DesiredReplicas, status.AvailableReplicas))
+			helper.LogError.Printf(errorMessage)
+			return
+		case <-r.Context().Done():
+			//The caller went away or the server is shutting down
+			return
+		}
+	}
+}
+
+func writeDeploymentStatus(w http.ResponseWriter, status *deploymentStatus) {
+	js, err := json.Marshal(status)
+	if err != nil {
+		errorMessage := fmt.Sprintf("Error marshalling deployment status: %v\n", err)
+		helper.WriteError(w, errorMessage, http.StatusInternalServerError)
+		helper.LogError.Printf(errorMessage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(js)
+
+	helper.LogInfo.Printf("Got Status of Deployment: %s\n", status.Name)
+}
+
+//deploymentRolloutStatus reads a deployment and its pods into a deploymentStatus
+func deploymentRolloutStatus(namespace, name string) (*deploymentStatus, error) {
+	dep, err := client.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := unversioned.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := client.Pods(namespace).List(api.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status := &deploymentStatus{
+		Name:                dep.Name,
+		DesiredReplicas:     dep.Spec.Replicas,
+		CurrentReplicas:     dep.Status.Replicas,
+		UpdatedReplicas:     dep.Status.UpdatedReplicas,
+		AvailableReplicas:   dep.Status.AvailableReplicas,
+		UnavailableReplicas: dep.Status.UnavailableReplicas,
+		Pods:                []podStatus{},
+	}
+
+	stalled := false
+	for _, pod := range podList.Items {
+		podStat := describePod(pod)
+		if stalledReasons[podStat.WaitingReason] {
+			stalled = true
+		}
+		status.Pods = append(status.Pods, podStat)
+	}
+	sort.Sort(podsByName(status.Pods))
+
+	switch {
+	case rolloutComplete(dep):
+		status.Rollout = rolloutFinished
+	case stalled:
+		status.Rollout = rolloutStalled
+	default:
+		status.Rollout = rolloutProgressing
+	}
+	return status, nil
+}
+
+//rolloutComplete is true once the controller has seen the latest spec and every replica is updated and available,
+//with none of the old ones left
+func rolloutComplete(dep *extensions.Deployment) bool {
+	return dep.Status.ObservedGeneration >= dep.Generation &&
+		dep.Status.UpdatedReplicas == dep.Spec.Replicas &&
+		dep.Status.Replicas == dep.Spec.Replicas &&
+		dep.Status.AvailableReplicas == dep.Spec.Replicas
+}
+
+//describePod summarizes a pod's health across its containers
+func describePod(pod api.Pod) podStatus {
+	podStat := podStatus{
+		Name:  pod.Name,
+		Phase: string(pod.Status.Phase),
+		Node:  pod.Spec.NodeName,
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == api.PodReady {
+			podStat.Ready = condition.Status == api.ConditionTrue
+		}
+	}
+
+	for _, container := range pod.Status.ContainerStatuses {
+		podStat.Restarts += container.RestartCount
+		if container.State.Waiting != nil && podStat.WaitingReason == "" {
+			podStat.WaitingReason = container.State.Waiting.Reason
+		}
+		if terminated := container.LastTerminationState.Terminated; terminated != nil {
+			podStat.LastTerminationReason = terminated.Reason
+		}
+	}
+	return podStat
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+//streams tracks the requests that only end with the client, shutdown cancels them
+var streams = &streamTracker{cancels: make(map[int]context.CancelFunc)}
+
+//streamTracker ends followed logs and watched events on shutdown.
+//Other requests keep their context so they get the shutdown timeout to finish.
+type streamTracker struct {
+	lock    sync.Mutex
+	next    int
+	cancels map[int]context.CancelFunc
+}
+
+//track returns the context of a stream, done when the request is or on shutdown.
+//The stream must call the returned cancel when it ends.
+func (t *streamTracker) track(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	t.lock.Lock()
+	id := t.next
+	t.next++
+	t.cancels[id] = cancel
+	t.lock.Unlock()
+
+	return ctx, func() {
+		t.lock.Lock()
+		delete(t.cancels, id)
+		t.lock.Unlock()
+		cancel()
+	}
+}
+
+//cancelAll ends every stream in progress
+func (t *streamTracker) cancelAll() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for id, cancel := range t.cancels {
+		cancel()
+		delete(t.cancels, id)
+	}
+}
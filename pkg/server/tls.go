@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/30x/enrober/pkg/helper"
+)
+
+//certReloadInterval is how often the certificate files are checked for changes
+const certReloadInterval = time.Minute
+
+//certReloader serves a certificate from files, loading them again when either one changes
+//so renewed certificates are picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+//newCertReloader loads the certificate once so a bad one fails at startup
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+//GetCertificate is used as tls.Config.GetCertificate, handshakes never touch the files
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.cert, nil
+}
+
+//run checks the files every interval until ctx is done
+func (c *certReloader) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.lock.Lock()
+			//A half written or mismatched pair keeps the previous certificate
+			err := c.reload()
+			c.lock.Unlock()
+			if err != nil {
+				helper.LogError.Printf("Error reloading TLS certificate: %v\n", err)
+			}
+		}
+	}
+}
+
+//reload loads the files if they changed since the last load.
+//Must be called with the lock held, or before the reloader is shared.
+func (c *certReloader) reload() error {
+	modTime, err := latestModTime(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("Error loading TLS certificate: %v", err)
+	}
+	if c.cert != nil {
+		helper.LogInfo.Printf("Reloaded TLS certificate %s\n", c.certFile)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+//latestModTime is the modification time of whichever file changed last
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
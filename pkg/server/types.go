@@ -4,11 +4,18 @@ import (
 	"net/http"
 
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+
+	"github.com/30x/enrober/pkg/config"
+	"github.com/30x/enrober/pkg/helper"
 )
 
 //Server struct
 type Server struct {
-	Router http.Handler
+	Router      http.Handler
+	AdminRouter http.Handler
+	config      *config.Config
 }
 
 type environmentPost struct {
@@ -32,6 +39,17 @@ type environmentResponse struct {
 	PrivateSecret []byte   `json:"privateSecret"`
 }
 
+type keyRotationPost struct {
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+}
+
+type keyRotationResponse struct {
+	Name               string           `json:"name"`
+	PublicSecret       []byte           `json:"publicSecret"`
+	PrivateSecret      []byte           `json:"privateSecret"`
+	PreviousKeysExpire unversioned.Time `json:"previousKeysExpire"`
+}
+
 type deploymentPost struct {
 	DeploymentName string               `json:"deploymentName"`
 	PublicHosts    *string              `json:"publicHosts,omitempty"`
@@ -40,6 +58,7 @@ type deploymentPost struct {
 	PtsURL         string               `json:"ptsURL,omitempty"`
 	PTS            *api.PodTemplateSpec `json:"pts,omitempty"`
 	EnvVars        []api.EnvVar         `json:"envVars,omitempty"`
+	Configs        []configMount        `json:"configs,omitempty"`
 }
 
 type deploymentPatch struct {
@@ -49,6 +68,117 @@ type deploymentPatch struct {
 	PtsURL       string               `json:"ptsURL"`
 	PTS          *api.PodTemplateSpec `json:"pts"`
 	EnvVars      []api.EnvVar         `json:"envVars,omitempty"`
+	Configs      []configMount        `json:"configs"`
+}
+
+//envVarPut sets an env var, secret ones are stored in a secret rather than the deployment
+type envVarPut struct {
+	Value  *string `json:"value"`
+	Secret bool    `json:"secret"`
+}
+
+//envVarResponse is an env var of a container, Value is only set for plain values
+type envVarResponse struct {
+	Name      string            `json:"name"`
+	Container string            `json:"container"`
+	Value     *string           `json:"value,omitempty"`
+	Secret    bool              `json:"secret"`
+	ValueFrom *api.EnvVarSource `json:"valueFrom,omitempty"`
+}
+
+//configMount mounts the files of a config in containers of a deployment, the first container when none are named
+type configMount struct {
+	Name       string   `json:"name"`
+	MountPath  string   `json:"mountPath"`
+	Containers []string `json:"containers,omitempty"`
+}
+
+//configPut sets the files of a config, keyed by file name
+type configPut struct {
+	Data map[string]string `json:"data"`
+}
+
+//configResponse is a config and the deployments that mount it
+type configResponse struct {
+	Name        string            `json:"name"`
+	Data        map[string]string `json:"data"`
+	Deployments []string          `json:"deployments"`
+}
+
+type configList struct {
+	Items []configResponse `json:"items"`
+}
+
+//configsByName sorts configResponses by name
+type configsByName []configResponse
+
+func (c configsByName) Len() int           { return len(c) }
+func (c configsByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c configsByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+
+//secretPut sets the keys of a user secret, values are given as strings
+type secretPut struct {
+	Data map[string]string `json:"data"`
+}
+
+//secretResponse is a user secret without its values, ResourceVersion changes whenever the secret does
+type secretResponse struct {
+	Name            string   `json:"name"`
+	Keys            []string `json:"keys"`
+	ResourceVersion string   `json:"resourceVersion"`
+	Deployments     []string `json:"deployments"`
+}
+
+type secretList struct {
+	Items []secretResponse `json:"items"`
+}
+
+//secretsByName sorts secretResponses by name
+type secretsByName []secretResponse
+
+func (s secretsByName) Len() int           { return len(s) }
+func (s secretsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s secretsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+type deploymentScale struct {
+	Replicas *int32 `json:"replicas"`
+}
+
+type autoscalerPut struct {
+	MinReplicas                    *int32 `json:"minReplicas,omitempty"`
+	MaxReplicas                    int32  `json:"maxReplicas"`
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+}
+
+//autoscalerResponse is the autoscaler of a deployment, the current fields are what it last saw
+type autoscalerResponse struct {
+	MinReplicas                     *int32            `json:"minReplicas,omitempty"`
+	MaxReplicas                     int32             `json:"maxReplicas"`
+	TargetCPUUtilizationPercentage  *int32            `json:"targetCPUUtilizationPercentage,omitempty"`
+	CurrentReplicas                 int32             `json:"currentReplicas"`
+	DesiredReplicas                 int32             `json:"desiredReplicas"`
+	CurrentCPUUtilizationPercentage *int32            `json:"currentCPUUtilizationPercentage,omitempty"`
+	LastScaleTime                   *unversioned.Time `json:"lastScaleTime,omitempty"`
+}
+
+//deploymentWithAutoscaler is a kubernetes deployment with the state of its autoscaler, if it has one
+type deploymentWithAutoscaler struct {
+	*extensions.Deployment
+	Autoscaler *autoscalerResponse `json:"autoscaler,omitempty"`
+}
+
+//deploymentDryRun is what an update would make of a deployment, and the fields it would change
+type deploymentDryRun struct {
+	Deployment *extensions.Deployment `json:"deployment"`
+	Diff       []fieldChange          `json:"diff"`
+}
+
+//fieldChange is one field of a deployment's JSON an update changes, Op is add, remove or replace
+type fieldChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
 }
 
 type deploymentResponse struct {
@@ -60,19 +190,148 @@ type deploymentResponse struct {
 	Replicas        int32                `json:"replicas"`
 	Environment     string               `json:"environment"`
 	PodTemplateSpec *api.PodTemplateSpec `json:"podTemplateSpec"`
+	Autoscaler      *autoscalerResponse  `json:"autoscaler,omitempty"`
+}
+
+//environmentDeletion lists which parts of an environment were removed when some of them couldn't be
+type environmentDeletion struct {
+	helper.ErrorResponse
+	Name    string            `json:"name"`
+	Deleted []string          `json:"deleted"`
+	Skipped []string          `json:"skipped,omitempty"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+//kvmStatus is whether an environment's routing KVM matches its routing secret, keys are never returned
+type kvmStatus struct {
+	Name      string `json:"name"`
+	KVMExists bool   `json:"kvmExists"`
+	InSync    bool   `json:"inSync"`
 }
 
-type apigeeKVMEntry struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+type deploymentRevision struct {
+	Revision    int64            `json:"revision"`
+	Images      []string         `json:"images"`
+	CreatedAt   unversioned.Time `json:"createdAt"`
+	ChangeCause string           `json:"changeCause,omitempty"`
 }
-type apigeeKVMBody struct {
-	Name  string           `json:"name"`
-	Entry []apigeeKVMEntry `json:"entry"`
+
+//byRevision sorts deploymentRevisions by ascending revision number
+type byRevision []deploymentRevision
+
+func (r byRevision) Len() int           { return len(r) }
+func (r byRevision) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byRevision) Less(i, j int) bool { return r[i].Revision < r[j].Revision }
+
+type deploymentRollback struct {
+	Revision int64 `json:"revision"`
+}
+
+type logRecord struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Timestamp string `json:"timestamp"`
+	Line      string `json:"line"`
 }
 
-type retryResponse struct {
-	Code     string   `json:"code"`
-	Message  string   `json:"message"`
-	Contexts []string `json:"contexts"`
+type readiness struct {
+	Ready        bool               `json:"ready"`
+	Dependencies []dependencyStatus `json:"dependencies"`
+}
+
+type dependencyStatus struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+//deploymentList is a page of deployments. Items are either extensions.Deployments or deploymentResponses
+//depending on the view, Continue is set when there are more pages.
+type deploymentList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+		Continue        string `json:"continue,omitempty"`
+	} `json:"metadata"`
+	Items interface{} `json:"items"`
+}
+
+type environmentList struct {
+	Items []environmentSummary `json:"items"`
+}
+
+//environmentSummary is an environment in a list, the routing keys are only filled in when asked for
+type environmentSummary struct {
+	Name          string   `json:"name"`
+	Organization  string   `json:"organization"`
+	Environment   string   `json:"environment"`
+	HostNames     []string `json:"hostNames,omitempty"`
+	Deployments   int      `json:"deployments"`
+	PublicSecret  []byte   `json:"publicSecret,omitempty"`
+	PrivateSecret []byte   `json:"privateSecret,omitempty"`
+}
+
+//environmentsByName sorts environmentSummaries by name
+type environmentsByName []environmentSummary
+
+func (e environmentsByName) Len() int           { return len(e) }
+func (e environmentsByName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e environmentsByName) Less(i, j int) bool { return e[i].Name < e[j].Name }
+
+//deploymentStatus is how far the rollout of a deployment has got. Rollout is finished, progressing or stalled.
+type deploymentStatus struct {
+	Name                string      `json:"name"`
+	Rollout             string      `json:"rollout"`
+	DesiredReplicas     int32       `json:"desiredReplicas"`
+	CurrentReplicas     int32       `json:"currentReplicas"`
+	UpdatedReplicas     int32       `json:"updatedReplicas"`
+	AvailableReplicas   int32       `json:"availableReplicas"`
+	UnavailableReplicas int32       `json:"unavailableReplicas"`
+	Pods                []podStatus `json:"pods"`
+}
+
+type podStatus struct {
+	Name                  string `json:"name"`
+	Phase                 string `json:"phase"`
+	Ready                 bool   `json:"ready"`
+	Restarts              int32  `json:"restarts"`
+	WaitingReason         string `json:"waitingReason,omitempty"`
+	LastTerminationReason string `json:"lastTerminationReason,omitempty"`
+	Node                  string `json:"node,omitempty"`
+}
+
+//podsByName sorts podStatuses by name
+type podsByName []podStatus
+
+func (p podsByName) Len() int           { return len(p) }
+func (p podsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p podsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+
+type eventRecordList struct {
+	Items []eventRecord `json:"items"`
+}
+
+//eventRecord is a kubernetes event, Type is Normal or Warning
+type eventRecord struct {
+	Type           string           `json:"type"`
+	Reason         string           `json:"reason"`
+	Message        string           `json:"message"`
+	InvolvedObject eventObject      `json:"involvedObject"`
+	Source         string           `json:"source,omitempty"`
+	Count          int32            `json:"count"`
+	FirstTimestamp unversioned.Time `json:"firstTimestamp"`
+	LastTimestamp  unversioned.Time `json:"lastTimestamp"`
+}
+
+type eventObject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+//eventsByTime sorts eventRecords oldest first
+type eventsByTime []eventRecord
+
+func (e eventsByTime) Len() int      { return len(e) }
+func (e eventsByTime) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e eventsByTime) Less(i, j int) bool {
+	return e[i].LastTimestamp.Before(e[j].LastTimestamp)
 }